@@ -0,0 +1,100 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+// General-purpose instructions with VEX prefix (BMI1 and BMI2).  VEX.W
+// selects 64-bit operand size.
+
+const (
+	vexBMI1 = uint32(FeatureBMI1) << 24
+	vexBMI2 = uint32(FeatureBMI2) << 24
+)
+
+type RVMvex uint32 // feature, fixed-length prefix, escape byte (0x38 or 0x3a) and opcode byte
+type RMVvex uint32 // like RVMvex
+type VMvex uint32  // feature, escape byte, opcode byte and ModRO byte
+type RMIvex uint32 // like RVMvex; imm8
+
+func (op RVMvex) Feature() Feature { return Feature(op >> 24) }
+func (op RMVvex) Feature() Feature { return Feature(op >> 24) }
+func (op VMvex) Feature() Feature  { return Feature(op >> 24) }
+func (op RMIvex) Feature() Feature { return Feature(op >> 24) }
+
+func (op RVMvex) vex(o *output, wrxb rexWRXB, v Reg) {
+	o.vex(escapeVexMap(byte(op>>8)), wrxb, v, vexL128, prefixVexPP(byte(op>>16)))
+	o.byte(byte(op))
+}
+
+func (op RVMvex) regRegReg(o *output, t Type, r, v, r2 Reg) {
+	op.vex(o, typeRexW(t)|regRexR(r)|regRexB(r2), v)
+	o.mod(ModReg, regRO(r), regRM(r2))
+}
+
+func (op RVMvex) regMemDisp(o *output, t Type, r, v, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	op.vex(o, typeRexW(t)|regRexR(r)|regRexB(base), v)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+}
+
+// RegRegReg encodes destination r, first source r1 and second source r2.
+func (op RVMvex) RegRegReg(text *Buf, t Type, r, r1, r2 Reg) {
+	var o output
+	op.regRegReg(&o, t, r, r1, r2)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RVMvex) RegRegMemDisp(text *Buf, t Type, r, r1, base Reg, disp int32) {
+	var o output
+	op.regMemDisp(&o, t, r, r1, base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegReg encodes destination r, source r2 and count (or index) r3.
+func (op RMVvex) RegRegReg(text *Buf, t Type, r, r2, r3 Reg) {
+	var o output
+	RVMvex(op).regRegReg(&o, t, r, r3, r2)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegMemDispReg encodes destination r, memory source and count (or index)
+// r3.
+func (op RMVvex) RegMemDispReg(text *Buf, t Type, r, base Reg, disp int32, r3 Reg) {
+	var o output
+	RVMvex(op).regMemDisp(&o, t, r, r3, base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op VMvex) RegReg(text *Buf, t Type, r, r2 Reg) {
+	var o output
+	o.vex(escapeVexMap(byte(op>>16)), typeRexW(t)|regRexB(r2), r, vexL128, vexPPNone)
+	o.byte(byte(op >> 8))
+	o.mod(ModReg, ModRO(op), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op VMvex) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(escapeVexMap(byte(op>>16)), typeRexW(t)|regRexB(base), r, vexL128, vexPPNone)
+	o.byte(byte(op >> 8))
+	o.mod(mod, ModRO(op), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIvex) RegRegImm8(text *Buf, t Type, r, r2 Reg, val int8) {
+	var o output
+	RVMvex(op).regRegReg(&o, t, r, vexNoReg, r2)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIvex) RegMemDispImm8(text *Buf, t Type, r, base Reg, disp int32, val int8) {
+	var o output
+	RVMvex(op).regMemDisp(&o, t, r, vexNoReg, base, disp)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
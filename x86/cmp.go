@@ -0,0 +1,19 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+// Immediate values for CMPPSD
+type CmpPredicate int8
+
+const (
+	CmpPredicateEQ    = CmpPredicate(0x0)
+	CmpPredicateLT    = CmpPredicate(0x1)
+	CmpPredicateLE    = CmpPredicate(0x2)
+	CmpPredicateUNORD = CmpPredicate(0x3)
+	CmpPredicateNEQ   = CmpPredicate(0x4)
+	CmpPredicateNLT   = CmpPredicate(0x5)
+	CmpPredicateNLE   = CmpPredicate(0x6)
+	CmpPredicateORD   = CmpPredicate(0x7)
+)
@@ -13,10 +13,19 @@ type Buffer interface {
 
 // Buf is an optimized Buffer.  The cached length (Addr) avoids interface
 // function calls.
+//
+// If VEX is set, vector instructions are encoded using the VEX prefix.  The
+// two-operand forms behave like their legacy SSE counterparts (the destination
+// is also the first source operand).
+//
+// If IBT is set, ENDBR64 is emitted at function entries and indirect branch
+// targets (CET indirect branch tracking).
 type Buf struct {
 	Buffer
-	Addr int32
+	Addr   int32
 	Errors []error
+	VEX    bool
+	IBT    bool
 }
 
 func (buf *Buf) Extend(n int) (b []byte) {
@@ -35,6 +44,16 @@ func (buf *Buf) PutUint32(x uint32) {
 	buf.Addr += 4
 }
 
+// BranchTarget returns the current address, to be recorded as a function
+// entry or an indirect branch target.  ENDBR64 is emitted if IBT is set.
+func (buf *Buf) BranchTarget() (addr int32) {
+	addr = buf.Addr
+	if buf.IBT {
+		ENDBR64.Simple(buf)
+	}
+	return
+}
+
 func (buf *Buf) Err(err error) {
 	if err != nil {
 		buf.Errors = append(buf.Errors, err)
@@ -16,8 +16,9 @@ var nops = [4][4]byte{
 	3: {0x0f, 0x1f, 0x00},
 }
 
-func typeScalarPrefix(t Type) byte { return byte(t)>>2 | 0xf2 } // 0xf3 or 0xf2
-func typeRMISizeCode(t Type) byte  { return byte(t)>>3 | 0x0a } // 0x0a or 0x0b
+func typeScalarPrefix(t Type) byte  { return byte(t)>>2 | 0xf2 } // 0xf3 or 0xf2
+func typeRMISizeCode(t Type) byte   { return byte(t)>>3 | 0x0a } // 0x0a or 0x0b
+func typeRMIPackedCode(t Type) byte { return byte(t)>>3 | 0x08 } // 0x08 or 0x09
 
 func addrDisp(currentAddr, insnSize, targetAddr int32) int32 {
 	if targetAddr != 0 {
@@ -63,6 +64,13 @@ func (o *output) rexIf(wrxb rexWRXB) {
 	o.offset += bit(wrxb != 0)
 }
 
+// rexByteIf is like rexIf, but the REX prefix is also appended if the 8-bit
+// register operand r is SPL, BPL, SIL or DIL.
+func (o *output) rexByteIf(wrxb rexWRXB, r Reg) {
+	o.buf[o.offset] = Rex | byte(wrxb)
+	o.offset += bit(wrxb != 0 || regRexByte(r))
+}
+
 func (o *output) mod(mod Mod, ro ModRO, rm ModRM) {
 	o.buf[o.offset] = byte(mod) | byte(ro) | byte(rm)
 	o.offset++
@@ -73,6 +81,37 @@ func (o *output) sib(s Scale, i Index, b Base) {
 	o.offset++
 }
 
+// segment appends the segment override prefix, if any.  It must precede the
+// operand-size, mandatory and REX prefixes.
+func (o *output) segment(seg Segment) {
+	o.byteIf(byte(seg), seg != NoSegment)
+}
+
+// memDisp appends ModRM byte and displacement of a base+disp memory operand.
+// If abs is set, base is ignored and the operand is an absolute 32-bit
+// address (encoded with SIB byte, because ModRM alone would mean RIP-relative).
+func (o *output) memDisp(abs bool, ro ModRO, base Reg, disp int32) {
+	if abs {
+		o.mod(ModMem, ro, ModRMSIB)
+		o.sib(Scale0, noIndex, noBase)
+		o.int32(disp)
+		return
+	}
+
+	var mod, dispSize = dispModSize(disp)
+	o.mod(mod, ro, regRM(base))
+	o.int(disp, dispSize)
+}
+
+// memIndexDisp appends ModRM byte, SIB byte and displacement of a
+// base+index*scale+disp memory operand.
+func (o *output) memIndexDisp(ro ModRO, base, index Reg, s Scale, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	o.mod(mod, ro, ModRMSIB)
+	o.sib(s, regIndex(index), regBase(base))
+	o.int(disp, dispSize)
+}
+
 func (o *output) int8(val int8) {
 	o.buf[o.offset] = uint8(val)
 	o.offset++
@@ -99,6 +138,28 @@ func (o *output) int(val int32, size uint8) {
 	o.offset += size
 }
 
+// sizePrefix appends operand-size prefix and REX prefix (if needed).  rexByte
+// forces a REX prefix for 8-bit register operands.  It reports an error if the
+// size is not B, W, L or Q.
+func (o *output) sizePrefix(text *Buf, sz Size, rxb rexWRXB, rexByte bool, op interface{}) bool {
+	switch sz {
+	case Byte, Long:
+
+	case Word:
+		o.byte(0x66)
+
+	case Quad:
+		rxb |= RexW
+
+	default:
+		text.Err(errors.Errorf("missing encoding for %T op=%x size=%v addr=%v", op, op, sz, text.Addr))
+		return false
+	}
+
+	o.byteIf(Rex|byte(rxb), rxb != 0 || (rexByte && sz == Byte))
+	return true
+}
+
 // NP
 
 type NP byte
@@ -114,6 +175,32 @@ func (op NP) Simple(text *Buf) {
 	text.PutByte(byte(op))
 }
 
+// NP with three opcode bytes
+
+type NP3 uint32
+
+func (op NP3) Simple(text *Buf) {
+	var o output
+	o.byte(byte(op >> 16))
+	o.word(uint16(op))
+	o.copy(text.Extend(o.len()))
+}
+
+// NP with four opcode bytes
+
+type NP4 uint32
+
+func (op NP4) put(o *output) {
+	o.word(uint16(op >> 16))
+	o.word(uint16(op))
+}
+
+func (op NP4) Simple(text *Buf) {
+	var o output
+	op.put(&o)
+	o.copy(text.Extend(o.len()))
+}
+
 // NP with fixed 0xf3 prefix
 
 type NPprefix byte
@@ -125,12 +212,32 @@ func (op NPprefix) Simple(text *Buf) {
 	o.copy(text.Extend(o.len()))
 }
 
+func (op NPprefix) Type(text *Buf, t Type) {
+	var o output
+	o.byte(0xf3)
+	o.rexIf(typeRexW(t))
+	o.byte(byte(op))
+	o.copy(text.Extend(o.len()))
+}
+
 // O
 
 type O byte
 
 func (op O) Reg(text *Buf, r Reg) { text.PutByte(byte(op) + byte(r)) }
 
+// O with two opcode bytes
+
+type O2 uint16 // two opcode bytes
+
+func (op O2) Reg(text *Buf, t Type, r Reg) {
+	var o output
+	o.rexIf(typeRexW(t) | regRexB(r))
+	o.byte(byte(op >> 8))
+	o.byte(byte(op) + byte(r)&7)
+	o.copy(text.Extend(o.len()))
+}
+
 // M
 
 type M uint16 // opcode byte and ModRO byte
@@ -143,6 +250,81 @@ func (op M) Reg(text *Buf, t Type, r Reg) {
 	o.copy(text.Extend(o.len()))
 }
 
+// sizeOK reports if the operand size can be encoded: PUSH and POP have only
+// W and Q variants, and the 0xff group has 8-bit variants only for INC and DEC.
+func (op M) sizeOK(sz Size) bool {
+	switch {
+	case op>>8 == 0x8f, op == PUSH:
+		return sz == Word || sz == Quad
+
+	case op>>8 == 0xff && ModRO(op) > 1<<opcodeBase:
+		return sz != Byte
+
+	default:
+		return true
+	}
+}
+
+// SizeMemDisp derives the 8-bit variant's opcode by clearing the low bit.
+func (op M) SizeMemDisp(text *Buf, sz Size, base Reg, disp int32) {
+	op.sizeMemDisp(text, NoSegment, false, sz, base, disp)
+}
+
+func (op M) SegSizeMemDisp(text *Buf, seg Segment, sz Size, base Reg, disp int32) {
+	op.sizeMemDisp(text, seg, false, sz, base, disp)
+}
+
+func (op M) SegSizeAbs(text *Buf, seg Segment, sz Size, disp int32) {
+	op.sizeMemDisp(text, seg, true, sz, 0, disp)
+}
+
+func (op M) sizeMemDisp(text *Buf, seg Segment, abs bool, sz Size, base Reg, disp int32) {
+	if !op.sizeOK(sz) {
+		text.Err(errors.Errorf("missing encoding for M op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	var o output
+	o.segment(seg)
+	if o.sizePrefix(text, sz, regRexB(base), false, op) {
+		o.byte(byte(op>>8) &^ bit(sz == Byte))
+		o.memDisp(abs, ModRO(op), base, disp)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// M with 64-bit operand size by default (no type)
+
+type M64 uint16 // opcode byte and ModRO byte
+
+func (op M64) Reg(text *Buf, r Reg) {
+	var o output
+	o.rexIf(regRexB(r))
+	o.byte(byte(op >> 8))
+	o.mod(ModReg, ModRO(op), regRM(r))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op M64) MemDisp(text *Buf, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.rexIf(regRexB(base))
+	o.byte(byte(op >> 8))
+	o.mod(mod, ModRO(op), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op M64) MemIndexDisp(text *Buf, base, index Reg, s Scale, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.rexIf(regRexX(index) | regRexB(base))
+	o.byte(byte(op >> 8))
+	o.mod(mod, ModRO(op), ModRMSIB)
+	o.sib(s, regIndex(index), regBase(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
 // M instructions which require rex byte with register operand
 
 type Mex2 uint16 // two opcode bytes
@@ -177,47 +359,124 @@ func (op RM2) RegReg(text *Buf, t Type, r, r2 Reg) {
 }
 
 func (op RM) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+	op.regMemDisp(text, NoSegment, false, t, r, base, disp)
+}
+
+func (op RM) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, t, r, base, disp)
+}
+
+// SegRegAbs accesses absolute (sign-extended 32-bit) address disp, e.g. an
+// offset in a thread-local block.
+func (op RM) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, t, r, 0, disp)
+}
+
+func (op RM) regMemDisp(text *Buf, seg Segment, abs bool, t Type, r, base Reg, disp int32) {
 	var o output
+	o.segment(seg)
 	o.rexIf(typeRexW(t) | regRexR(r) | regRexB(base))
 	o.byte(byte(op))
-	o.mod(mod, regRO(r), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, regRO(r), base, disp)
 	o.copy(text.Extend(o.len()))
 }
 
 func (op RM2) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+	op.regMemDisp(text, NoSegment, false, t, r, base, disp)
+}
+
+func (op RM2) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, t, r, base, disp)
+}
+
+func (op RM2) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, t, r, 0, disp)
+}
+
+func (op RM2) regMemDisp(text *Buf, seg Segment, abs bool, t Type, r, base Reg, disp int32) {
 	var o output
+	o.segment(seg)
 	o.rexIf(typeRexW(t) | regRexR(r) | regRexB(base))
 	o.word(uint16(op))
-	o.mod(mod, regRO(r), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, regRO(r), base, disp)
 	o.copy(text.Extend(o.len()))
 }
 
 func (op RM) RegMemIndexDisp(text *Buf, t Type, r, base Reg, index Reg, s Scale, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+	op.SegRegMemIndexDisp(text, NoSegment, t, r, base, index, s, disp)
+}
+
+func (op RM) SegRegMemIndexDisp(text *Buf, seg Segment, t Type, r, base Reg, index Reg, s Scale, disp int32) {
 	var o output
+	o.segment(seg)
 	o.rexIf(typeRexW(t) | regRexR(r) | regRexX(index) | regRexB(base))
 	o.byte(byte(op))
-	o.mod(mod, regRO(r), ModRMSIB)
-	o.sib(s, regIndex(index), regBase(base))
-	o.int(disp, dispSize)
+	o.memIndexDisp(regRO(r), base, index, s, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RM2) RegMemIndexDisp(text *Buf, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	op.SegRegMemIndexDisp(text, NoSegment, t, r, base, index, s, disp)
+}
+
+func (op RM2) SegRegMemIndexDisp(text *Buf, seg Segment, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	var o output
+	o.segment(seg)
+	o.rexIf(typeRexW(t) | regRexR(r) | regRexX(index) | regRexB(base))
+	o.word(uint16(op))
+	o.memIndexDisp(regRO(r), base, index, s, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+// RM with two opcode bytes and 8-bit source operand
+
+type RM2src8 uint16 // two opcode bytes
+
+func (op RM2src8) RegReg(text *Buf, t Type, r, r2 Reg) {
+	var o output
+	o.rexByteIf(typeRexW(t)|regRexR(r)|regRexB(r2), r2)
+	o.word(uint16(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
 	o.copy(text.Extend(o.len()))
 }
 
+func (op RM2src8) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
+	RM2(op).RegMemDisp(text, t, r, base, disp)
+}
+
+func (op RM2src8) RegMemIndexDisp(text *Buf, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	RM2(op).RegMemIndexDisp(text, t, r, base, index, s, disp)
+}
+
+func (op RM2src8) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	RM2(op).SegRegMemDisp(text, seg, t, r, base, disp)
+}
+
+func (op RM2src8) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	RM2(op).SegRegAbs(text, seg, t, r, disp)
+}
+
+func (op RM2src8) SegRegMemIndexDisp(text *Buf, seg Segment, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	RM2(op).SegRegMemIndexDisp(text, seg, t, r, base, index, s, disp)
+}
+
 // RM (MR) with prefix and two opcode bytes (first byte hardcoded)
 
-type RMprefix uint16    // fixed-length prefix and second opcode byte
-type RMprefixnt uint16  // fixed-length prefix and second opcode byte; single data-size (no type)
-type RMscalar byte      // second opcode byte; type-dependent fixed-length prefix
-type RMpacked byte      // second opcode byte; type-dependent variable-length prefix
-type RMpackedsz uint32  // op-code set for B/W/L/Q elements; 0x66 prefix without type-dependent REX.W
-type RMIpackedsz string // op-code set for B/W/L/Q/DQ elements with RO; 0x66 prefix without type-dependent REX.W; imm8
-type Pminmax string     // placeholder for PMIN/PMAX instructions
-type PBlendi uint32     // placeholder for BLEND instructions with imm8
-type PShufi string      // placeholder for SHUF instructions with imm8
+type RMprefix uint16        // fixed-length prefix and second opcode byte
+type RMprefixnt uint16      // optional fixed-length prefix and second opcode byte; single data-size (no type)
+type RMprefix38nt uint16    // fixed-length prefix and third opcode byte (0x38-escaped); single data-size (no type)
+type RMscalar byte          // second opcode byte; type-dependent fixed-length prefix
+type RMpacked byte          // second opcode byte; type-dependent variable-length prefix
+type RMpackedsz uint32      // op-code set for B/W/L/Q elements; 0x66 prefix without type-dependent REX.W
+type RMIpackedsz string     // op-code set for B/W/L/Q/DQ elements with RO; 0x66 prefix without type-dependent REX.W; imm8
+type RMpackedsz38 string    // op-code pairs for B/W/L/Q elements, 0x38-escaped if first byte is 0x38; 0x66 prefix
+type Pminmax = RMpackedsz38 // PMIN/PMAX instructions
+type PBlendi uint32         // placeholder for BLEND instructions with imm8
+type PShufi string          // placeholder for SHUF and ALIGN instructions with imm8
+type CmpPacked byte         // second opcode byte; type-dependent variable-length prefix; predicate imm8
+type RMIlane string         // op-code pairs for B/W/L/Q lanes, 0x3a-escaped if first byte is 0x3a; 0x66 prefix; imm8
+type PMovx byte             // placeholder for PMOVSX/PMOVZX instructions (0x38-escaped opcode of BW variant)
+type RMblendv uint16        // VEX opcode byte (0x3a-escaped) and legacy opcode byte (0x38-escaped); 0x66 prefix; mask operand
 
 func (op RMprefix) RegReg(text *Buf, t Type, r, r2 Reg) {
 	var o output
@@ -230,16 +489,39 @@ func (op RMprefix) RegReg(text *Buf, t Type, r, r2 Reg) {
 }
 
 func (op RMprefixnt) RegReg(text *Buf, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, r, op.vexV(r), r2)
+		return
+	}
+	var o output
+	o.byteIf(byte(op>>8), op>>8 != 0)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMprefix38nt) RegReg(text *Buf, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, r, op.vexV(r), r2)
+		return
+	}
 	var o output
 	o.byte(byte(op >> 8))
 	o.rexIf(regRexR(r) | regRexB(r2))
 	o.byte(0x0f)
+	o.byte(0x38)
 	o.byte(byte(op))
 	o.mod(ModReg, regRO(r), regRM(r2))
 	o.copy(text.Extend(o.len()))
 }
 
 func (op RMscalar) RegReg(text *Buf, t Type, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, t, OneSize, r, op.vexV(r, r2), r2)
+		return
+	}
 	var o output
 	o.byte(typeScalarPrefix(t))
 	o.rexIf(regRexR(r) | regRexB(r2))
@@ -250,6 +532,10 @@ func (op RMscalar) RegReg(text *Buf, t Type, r, r2 Reg) {
 }
 
 func (op RMpacked) RegReg(text *Buf, t Type, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, t, r, op.vexV(r), r2)
+		return
+	}
 	var o output
 	o.byteIf(0x66, t&8 == 8)
 	o.rexIf(regRexR(r) | regRexB(r2))
@@ -266,6 +552,10 @@ func (op RMpackedsz) opByte(sz Size) (b byte, ok bool) {
 }
 
 func (op RMpackedsz) RegReg(text *Buf, sz Size, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, sz, r, r, r2)
+		return
+	}
 	var o output
 	bop, ok := op.opByte(sz)
 	if !ok {
@@ -280,21 +570,25 @@ func (op RMpackedsz) RegReg(text *Buf, sz Size, r, r2 Reg) {
 	o.copy(text.Extend(o.len()))
 }
 
-func (op Pminmax) opWord(sz Size) (w uint16, ok bool) {
+func (op RMpackedsz38) opWord(sz Size) (w uint16, ok bool) {
 	offset := bits.TrailingZeros8(uint8(sz))
 	if offset >= len(op)/2 {
 		return
 	}
 	w = uint16(op[offset*2]) | (uint16(op[offset*2+1])<<8)
-	ok = w != 0 && sz <= Long
+	ok = w != 0 && sz <= Quad
 	return
 }
 
-func (op Pminmax) RegReg(text *Buf, sz Size, r, r2 Reg) {
+func (op RMpackedsz38) RegReg(text *Buf, sz Size, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, sz, r, op.vexV(r), r2)
+		return
+	}
 	var o output
 	w, ok := op.opWord(sz)
 	if !ok {
-		text.Err(errors.Errorf("missing encoding for Pminmax op=%x size=%v addr=%v", op, sz, text.Addr))
+		text.Err(errors.Errorf("missing encoding for RMpackedsz38 op=%x size=%v addr=%v", op, sz, text.Addr))
 		return
 	}
 	o.byte(0x66)
@@ -316,6 +610,10 @@ func (op RMIpackedsz) opRoBytes(sz Size) (b, ro byte, ok bool) {
 }
 
 func (op RMIpackedsz) RegImm8(text *Buf, sz Size, r Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, vexL128, sz, r, r, val)
+		return
+	}
 	var o output
 	b, ro, ok := op.opRoBytes(sz)
 	if !ok {
@@ -338,6 +636,10 @@ func (op PBlendi) opByte(sz Size) (b byte, ok bool) {
 }
 
 func (op PBlendi) RegRegImm8(text *Buf, sz Size, r, r2 Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, vexL128, sz, r, r, r2, val)
+		return
+	}
 	var o output
 	b, ok := op.opByte(sz)
 	if !ok {
@@ -355,6 +657,10 @@ func (op PBlendi) RegRegImm8(text *Buf, sz Size, r, r2 Reg, val int8) {
 }
 
 func (op PShufi) RegRegImm8(text *Buf, r, r2 Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, vexL128, r, op.vexV(r), r2, val)
+		return
+	}
 	var o output
 	o.byteIf(op[0], op[0] != 0x0f)
 	o.rexIf(regRexR(r) | regRexB(r2))
@@ -367,7 +673,56 @@ func (op PShufi) RegRegImm8(text *Buf, r, r2 Reg, val int8) {
 	o.copy(text.Extend(o.len()))
 }
 
+// laneRexW returns RexW for 64-bit lanes.
+func laneRexW(sz Size) rexWRXB {
+	if sz == Quad {
+		return RexW
+	}
+	return 0
+}
+
+func (op RMIlane) RegRegImm8(text *Buf, sz Size, r, r2 Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, sz, r, op.vexV(r), r2, val)
+		return
+	}
+	var o output
+	w, ok := RMpackedsz38(op).opWord(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMIlane op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.byte(0x66)
+	o.rexIf(laneRexW(sz) | regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(byte(w))
+	w >>= 8
+	o.byteIf(byte(w), w != 0)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op CmpPacked) RegRegImm8(text *Buf, t Type, r, r2 Reg, pred CmpPredicate) {
+	if text.VEX {
+		op.vexRegRegImm8(text, vexL128, t, r, r, r2, pred)
+		return
+	}
+	var o output
+	o.byteIf(0x66, t&8 == 8)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(int8(pred))
+	o.copy(text.Extend(o.len()))
+}
+
 func (op RMscalar) TypeRegReg(text *Buf, floatType, intType Type, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, floatType, intType, r, op.vexV(r, r2), r2)
+		return
+	}
 	var o output
 	o.byte(typeScalarPrefix(floatType))
 	o.rexIf(typeRexW(intType) | regRexR(r) | regRexB(r2))
@@ -377,22 +732,58 @@ func (op RMscalar) TypeRegReg(text *Buf, floatType, intType Type, r, r2 Reg) {
 	o.copy(text.Extend(o.len()))
 }
 
+// RM with register operands only (no memory operand forms)
+
+type RRprefixnt RMprefixnt // like RMprefixnt
+type RRpacked RMpacked     // like RMpacked
+
+func (op RRprefixnt) RegReg(text *Buf, r, r2 Reg) {
+	if text.VEX {
+		RMprefixnt(op).vexRegReg(text, vexL128, r, vexNoReg, r2)
+		return
+	}
+	RMprefixnt(op).RegReg(text, r, r2)
+}
+
+func (op RRpacked) RegReg(text *Buf, t Type, r, r2 Reg) {
+	if text.VEX {
+		RMpacked(op).vexRegReg(text, vexL128, t, r, vexNoReg, r2)
+		return
+	}
+	RMpacked(op).RegReg(text, t, r, r2)
+}
+
 func (op RMprefix) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+	op.regMemDisp(text, NoSegment, false, t, r, base, disp)
+}
+
+func (op RMprefix) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, t, r, base, disp)
+}
+
+func (op RMprefix) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, t, r, 0, disp)
+}
+
+func (op RMprefix) regMemDisp(text *Buf, seg Segment, abs bool, t Type, r, base Reg, disp int32) {
 	var o output
+	o.segment(seg)
 	o.byte(byte(op >> 8))
 	o.rexIf(typeRexW(t) | regRexR(r) | regRexB(base))
 	o.byte(0x0f)
 	o.byte(byte(op))
-	o.mod(mod, regRO(r), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, regRO(r), base, disp)
 	o.copy(text.Extend(o.len()))
 }
 
 func (op RMprefixnt) RegMemDisp(text *Buf, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, vexL128, r, op.vexV(r), base, disp)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
-	o.byte(byte(op >> 8))
+	o.byteIf(byte(op>>8), op>>8 != 0)
 	o.rexIf(regRexR(r) | regRexB(base))
 	o.byte(0x0f)
 	o.byte(byte(op))
@@ -401,34 +792,86 @@ func (op RMprefixnt) RegMemDisp(text *Buf, r, base Reg, disp int32) {
 	o.copy(text.Extend(o.len()))
 }
 
-func (op RMscalar) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
+func (op RMprefix38nt) RegMemDisp(text *Buf, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, vexL128, r, op.vexV(r), base, disp)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
-	o.byte(typeScalarPrefix(t))
+	o.byte(byte(op >> 8))
 	o.rexIf(regRexR(r) | regRexB(base))
 	o.byte(0x0f)
+	o.byte(0x38)
 	o.byte(byte(op))
 	o.mod(mod, regRO(r), regRM(base))
 	o.int(disp, dispSize)
 	o.copy(text.Extend(o.len()))
 }
 
-func (op RMpacked) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+func (op RMscalar) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, NoSegment, false, t, r, base, disp)
+}
+
+func (op RMscalar) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, t, r, base, disp)
+}
+
+// SegRegAbs accesses absolute (sign-extended 32-bit) address disp.
+func (op RMscalar) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, t, r, 0, disp)
+}
+
+func (op RMscalar) regMemDisp(text *Buf, seg Segment, abs bool, t Type, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, seg, abs, t, r, op.vexMemV(r), base, disp)
+		return
+	}
 	var o output
-	o.byteIf(0x66, t&8 == 8)
+	o.segment(seg)
+	o.byte(typeScalarPrefix(t))
 	o.rexIf(regRexR(r) | regRexB(base))
 	o.byte(0x0f)
 	o.byte(byte(op))
-	o.mod(mod, regRO(r), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, regRO(r), base, disp)
 	o.copy(text.Extend(o.len()))
 }
 
-func (op RMpackedsz) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
-	var o output
-	bop, ok := op.opByte(sz)
+func (op RMpacked) RegMemDisp(text *Buf, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, NoSegment, false, t, r, base, disp)
+}
+
+func (op RMpacked) SegRegMemDisp(text *Buf, seg Segment, t Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, t, r, base, disp)
+}
+
+func (op RMpacked) SegRegAbs(text *Buf, seg Segment, t Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, t, r, 0, disp)
+}
+
+func (op RMpacked) regMemDisp(text *Buf, seg Segment, abs bool, t Type, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, seg, abs, vexL128, t, r, op.vexV(r), base, disp)
+		return
+	}
+	var o output
+	o.segment(seg)
+	o.byteIf(0x66, t&8 == 8)
+	o.rexIf(regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.memDisp(abs, regRO(r), base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMpackedsz) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, vexL128, sz, r, r, base, disp)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	bop, ok := op.opByte(sz)
 	if !ok {
 		text.Err(errors.Errorf("missing encoding for RMpackedsz op=%x size=%v addr=%v", op, sz, text.Addr))
 		return
@@ -443,6 +886,10 @@ func (op RMpackedsz) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
 }
 
 func (op PBlendi) RegMemDispImm8(text *Buf, sz Size, r, base Reg, disp int32, val int8) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, vexL128, sz, r, r, base, disp, val)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
 	b, ok := op.opByte(sz)
@@ -462,6 +909,10 @@ func (op PBlendi) RegMemDispImm8(text *Buf, sz Size, r, base Reg, disp int32, va
 }
 
 func (op PShufi) RegMemDispImm8(text *Buf, r, base Reg, disp int32, val int8) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, vexL128, r, op.vexV(r), base, disp, val)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
 	o.byteIf(op[0], op[0] != 0x0f)
@@ -476,12 +927,57 @@ func (op PShufi) RegMemDispImm8(text *Buf, r, base Reg, disp int32, val int8) {
 	o.copy(text.Extend(o.len()))
 }
 
-func (op Pminmax) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
+func (op RMIlane) RegMemDispImm8(text *Buf, sz Size, r, base Reg, disp int32, val int8) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, sz, r, op.vexV(r), base, disp, val)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	w, ok := RMpackedsz38(op).opWord(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMIlane op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.byte(0x66)
+	o.rexIf(laneRexW(sz) | regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(w))
+	w >>= 8
+	o.byteIf(byte(w), w != 0)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op CmpPacked) RegMemDispImm8(text *Buf, t Type, r, base Reg, disp int32, pred CmpPredicate) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, vexL128, t, r, r, base, disp, pred)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.byteIf(0x66, t&8 == 8)
+	o.rexIf(regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(int8(pred))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMpackedsz38) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, vexL128, sz, r, op.vexV(r), base, disp)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
 	w, ok := op.opWord(sz)
 	if !ok {
-		text.Err(errors.Errorf("missing encoding for Pminmax op=%x size=%v addr=%v", op, sz, text.Addr))
+		text.Err(errors.Errorf("missing encoding for RMpackedsz38 op=%x size=%v addr=%v", op, sz, text.Addr))
 		return
 	}
 	o.byte(0x66)
@@ -495,31 +991,311 @@ func (op Pminmax) RegMemDisp(text *Buf, sz Size, r, base Reg, disp int32) {
 	o.copy(text.Extend(o.len()))
 }
 
-// RM instructions with 8-bit operand size
+// checkMask reports an error if the mask operand of the legacy encoding is
+// not XMM0.
+func (op RMblendv) checkMask(text *Buf, mask Reg) bool {
+	if mask != 0 {
+		text.Err(errors.Errorf("mask operand must be XMM0 for RMblendv op=%x mask=%v addr=%v", op, mask, text.Addr))
+		return false
+	}
+	return true
+}
 
-type RMdata8 byte // opcode byte
+// RegRegMask blends r and r2 according to mask, which must be XMM0.
+func (op RMblendv) RegRegMask(text *Buf, r, r2, mask Reg) {
+	if !op.checkMask(text, mask) {
+		return
+	}
+	if text.VEX {
+		op.vexRegRegMask(text, vexL128, r, r, r2, mask)
+		return
+	}
+	var o output
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(0x38)
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
 
-func (op RMdata8) RegMemDisp(text *Buf, _ Type, r, base Reg, disp int32) {
+// RegMemDispMask blends r and memory according to mask, which must be XMM0.
+func (op RMblendv) RegMemDispMask(text *Buf, r, base Reg, disp int32, mask Reg) {
+	if !op.checkMask(text, mask) {
+		return
+	}
+	if text.VEX {
+		op.vexRegMemDispMask(text, vexL128, r, r, base, disp, mask)
+		return
+	}
 	var mod, dispSize = dispModSize(disp)
 	var o output
-	o.rex(regRexR(r) | regRexB(base))
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(0x38)
 	o.byte(byte(op))
 	o.mod(mod, regRO(r), regRM(base))
 	o.int(disp, dispSize)
 	o.copy(text.Extend(o.len()))
 }
 
+// opByte returns the opcode for extension from source to destination element
+// size.
+func (op PMovx) opByte(from, to Size) (b byte, ok bool) {
+	switch from<<4 | to {
+	case Byte<<4 | Word:
+		b = 0
+
+	case Byte<<4 | Long:
+		b = 1
+
+	case Byte<<4 | Quad:
+		b = 2
+
+	case Word<<4 | Long:
+		b = 3
+
+	case Word<<4 | Quad:
+		b = 4
+
+	case Long<<4 | Quad:
+		b = 5
+
+	default:
+		return
+	}
+	return byte(op) + b, true
+}
+
+func (op PMovx) RegReg(text *Buf, from, to Size, r, r2 Reg) {
+	if text.VEX {
+		op.vexRegReg(text, vexL128, from, to, r, r2)
+		return
+	}
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(0x38)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PMovx) RegMemDisp(text *Buf, from, to Size, r, base Reg, disp int32) {
+	if text.VEX {
+		op.vexRegMemDisp(text, vexL128, from, to, r, base, disp)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(0x38)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PMovx) RegMemIndexDisp(text *Buf, from, to Size, r, base, index Reg, s Scale, disp int32) {
+	if text.VEX {
+		op.vexRegMemIndexDisp(text, vexL128, from, to, r, base, index, s, disp)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexX(index) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(0x38)
+	o.byte(b)
+	o.mod(mod, regRO(r), ModRMSIB)
+	o.sib(s, regIndex(index), regBase(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// RM instructions with 8-bit operand size
+
+type RMdata8 byte // opcode byte
+
+func (op RMdata8) RegMemDisp(text *Buf, _ Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, NoSegment, false, r, base, disp)
+}
+
+func (op RMdata8) SegRegMemDisp(text *Buf, seg Segment, _ Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, r, base, disp)
+}
+
+func (op RMdata8) SegRegAbs(text *Buf, seg Segment, _ Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, r, 0, disp)
+}
+
+func (op RMdata8) regMemDisp(text *Buf, seg Segment, abs bool, r, base Reg, disp int32) {
+	var o output
+	o.segment(seg)
+	o.rexByteIf(regRexR(r)|regRexB(base), r)
+	o.byte(byte(op))
+	o.memDisp(abs, regRO(r), base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMdata8) RegMemIndexDisp(text *Buf, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	op.SegRegMemIndexDisp(text, NoSegment, t, r, base, index, s, disp)
+}
+
+func (op RMdata8) SegRegMemIndexDisp(text *Buf, seg Segment, _ Type, r, base Reg, index Reg, s Scale, disp int32) {
+	var o output
+	o.segment(seg)
+	o.rexByteIf(regRexR(r)|regRexX(index)|regRexB(base), r)
+	o.byte(byte(op))
+	o.memIndexDisp(regRO(r), base, index, s, disp)
+	o.copy(text.Extend(o.len()))
+}
+
 // RM instructions with 16-bit operand size
 
 type RMdata16 byte // opcode byte
 
 func (op RMdata16) RegMemDisp(text *Buf, _ Type, r, base Reg, disp int32) {
-	var mod, dispSize = dispModSize(disp)
+	op.regMemDisp(text, NoSegment, false, r, base, disp)
+}
+
+func (op RMdata16) SegRegMemDisp(text *Buf, seg Segment, _ Type, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, r, base, disp)
+}
+
+func (op RMdata16) SegRegAbs(text *Buf, seg Segment, _ Type, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, r, 0, disp)
+}
+
+func (op RMdata16) regMemDisp(text *Buf, seg Segment, abs bool, r, base Reg, disp int32) {
 	var o output
+	o.segment(seg)
 	o.byte(0x66)
 	o.rexIf(regRexR(r) | regRexB(base))
 	o.byte(byte(op))
-	o.mod(mod, regRO(r), regRM(base))
+	o.memDisp(abs, regRO(r), base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMdata16) RegMemIndexDisp(text *Buf, t Type, r, base Reg, index Reg, s Scale, disp int32) {
+	op.SegRegMemIndexDisp(text, NoSegment, t, r, base, index, s, disp)
+}
+
+func (op RMdata16) SegRegMemIndexDisp(text *Buf, seg Segment, _ Type, r, base Reg, index Reg, s Scale, disp int32) {
+	var o output
+	o.segment(seg)
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexX(index) | regRexB(base))
+	o.byte(byte(op))
+	o.memIndexDisp(regRO(r), base, index, s, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+// RM (MR) with optional LOCK prefix and element-size-dependent opcode
+
+type RMlock uint16 // opcode of 8-bit variant (0x0f-escaped if high byte is 0x0f); incremented for other sizes
+
+// prefix appends the optional segment override and LOCK prefixes,
+// operand-size prefix, REX prefix and opcode.  rexByte forces a REX prefix for
+// 8-bit register operands.
+func (op RMlock) prefix(text *Buf, o *output, seg Segment, lock bool, sz Size, rxb rexWRXB, rexByte bool) bool {
+	o.segment(seg)
+	o.byteIf(0xf0, lock)
+	if !o.sizePrefix(text, sz, rxb, rexByte, op) {
+		return false
+	}
+	o.byteIf(0x0f, op>>8 == 0x0f)
+	o.byte(byte(op) + bit(sz != Byte))
+	return true
+}
+
+// RegReg can't be locked.
+func (op RMlock) RegReg(text *Buf, sz Size, r, r2 Reg) {
+	var o output
+	if op.prefix(text, &o, NoSegment, false, sz, regRexR(r)|regRexB(r2), regRexByte(r) || regRexByte(r2)) {
+		o.mod(ModReg, regRO(r), regRM(r2))
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+func (op RMlock) RegMemDisp(text *Buf, lock bool, sz Size, r, base Reg, disp int32) {
+	op.regMemDisp(text, NoSegment, false, lock, sz, r, base, disp)
+}
+
+func (op RMlock) SegRegMemDisp(text *Buf, seg Segment, lock bool, sz Size, r, base Reg, disp int32) {
+	op.regMemDisp(text, seg, false, lock, sz, r, base, disp)
+}
+
+func (op RMlock) SegRegAbs(text *Buf, seg Segment, lock bool, sz Size, r Reg, disp int32) {
+	op.regMemDisp(text, seg, true, lock, sz, r, 0, disp)
+}
+
+func (op RMlock) regMemDisp(text *Buf, seg Segment, abs, lock bool, sz Size, r, base Reg, disp int32) {
+	var o output
+	if op.prefix(text, &o, seg, lock, sz, regRexR(r)|regRexB(base), regRexByte(r)) {
+		o.memDisp(abs, regRO(r), base, disp)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+func (op RMlock) RegMemIndexDisp(text *Buf, lock bool, sz Size, r, base, index Reg, s Scale, disp int32) {
+	op.SegRegMemIndexDisp(text, NoSegment, lock, sz, r, base, index, s, disp)
+}
+
+func (op RMlock) SegRegMemIndexDisp(text *Buf, seg Segment, lock bool, sz Size, r, base, index Reg, s Scale, disp int32) {
+	var o output
+	if op.prefix(text, &o, seg, lock, sz, regRexR(r)|regRexX(index)|regRexB(base), regRexByte(r)) {
+		o.memIndexDisp(regRO(r), base, index, s, disp)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// M with optional LOCK prefix, two opcode bytes (first byte hardcoded) and REX.W
+
+type M2lock uint16 // second opcode byte and ModRO byte
+
+func (op M2lock) MemDisp(text *Buf, lock bool, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.byteIf(0xf0, lock)
+	o.rex(RexW | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(op >> 8))
+	o.mod(mod, ModRO(op), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op M2lock) MemIndexDisp(text *Buf, lock bool, base, index Reg, s Scale, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.byteIf(0xf0, lock)
+	o.rex(RexW | regRexX(index) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(op >> 8))
+	o.mod(mod, ModRO(op), ModRMSIB)
+	o.sib(s, regIndex(index), regBase(base))
 	o.int(disp, dispSize)
 	o.copy(text.Extend(o.len()))
 }
@@ -580,6 +1356,78 @@ func (op MI) RegImm32(text *Buf, t Type, r Reg, val int32) {
 	o.copy(text.Extend(o.len()))
 }
 
+// SizeMemDispImm selects 8-bit or 32-bit immediate like RegImm.  The immediate
+// is 8-bit with Byte size, and 16-bit instead of 32-bit with Word size; it
+// reports an error if the value doesn't fit (signed or unsigned).
+func (ops MI) SizeMemDispImm(text *Buf, sz Size, base Reg, disp int32, val int32) {
+	ops.sizeMemDispImm(text, NoSegment, false, sz, base, disp, val)
+}
+
+func (ops MI) SegSizeMemDispImm(text *Buf, seg Segment, sz Size, base Reg, disp int32, val int32) {
+	ops.sizeMemDispImm(text, seg, false, sz, base, disp, val)
+}
+
+// SegSizeAbsImm accesses absolute (sign-extended 32-bit) address disp.
+func (ops MI) SegSizeAbsImm(text *Buf, seg Segment, sz Size, disp int32, val int32) {
+	ops.sizeMemDispImm(text, seg, true, sz, 0, disp, val)
+}
+
+func (ops MI) sizeMemDispImm(text *Buf, seg Segment, abs bool, sz Size, base Reg, disp int32, val int32) {
+	var op, valSize = immOpcodeSize(uint16(ops>>8), val)
+
+	switch sz {
+	case Byte:
+		if uint32(val+0x80) > 0x17f {
+			text.Err(errors.Errorf("immediate value out of range for MI op=%x size=%v val=%d addr=%v", ops, sz, val, text.Addr))
+			return
+		}
+		op, valSize = byte(ops>>8)&^3, 1 // 0x83 => 0x80
+
+	case Word:
+		if uint32(val+0x8000) > 0x17fff {
+			text.Err(errors.Errorf("immediate value out of range for MI op=%x size=%v val=%d addr=%v", ops, sz, val, text.Addr))
+			return
+		}
+		if valSize == 4 {
+			valSize = 2
+		}
+	}
+
+	var o output
+	o.segment(seg)
+	if o.sizePrefix(text, sz, regRexB(base), false, ops) {
+		o.byte(op)
+		o.memDisp(abs, ModRO(ops), base, disp)
+		o.int(val, valSize)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// SizeMemDispImm8 derives the 8-bit variant's opcode by clearing the low bits
+// of the 8-bit immediate opcode.
+func (op MI) SizeMemDispImm8(text *Buf, sz Size, base Reg, disp int32, val int8) {
+	op.sizeMemDispImm8(text, NoSegment, false, sz, base, disp, val)
+}
+
+func (op MI) SegSizeMemDispImm8(text *Buf, seg Segment, sz Size, base Reg, disp int32, val int8) {
+	op.sizeMemDispImm8(text, seg, false, sz, base, disp, val)
+}
+
+func (op MI) SegSizeAbsImm8(text *Buf, seg Segment, sz Size, disp int32, val int8) {
+	op.sizeMemDispImm8(text, seg, true, sz, 0, disp, val)
+}
+
+func (op MI) sizeMemDispImm8(text *Buf, seg Segment, abs bool, sz Size, base Reg, disp int32, val int8) {
+	var o output
+	o.segment(seg)
+	if o.sizePrefix(text, sz, regRexB(base), false, op) {
+		o.byte(byte(op>>8) &^ (bit(sz == Byte) * 3)) // 0x83 => 0x80, 0xc1 => 0xc0
+		o.memDisp(abs, ModRO(op), base, disp)
+		o.int8(val)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
 // MI instructions with 8-bit operand size implementing generic interface
 
 type MI8 uint16 // opcode byte and ModRO byte
@@ -595,12 +1443,26 @@ func (op MI8) OneSizeRegImm(text *Buf, r Reg, val8 int64) {
 
 // MemDispImm ignores the type argument.
 func (op MI8) MemDispImm(text *Buf, _ Type, base Reg, disp int32, val8 int64) {
-	var mod, dispSize = dispModSize(disp)
+	op.memDispImm(text, NoSegment, false, base, disp, val8)
+}
+
+// SegMemDispImm ignores the type argument.
+func (op MI8) SegMemDispImm(text *Buf, seg Segment, _ Type, base Reg, disp int32, val8 int64) {
+	op.memDispImm(text, seg, false, base, disp, val8)
+}
+
+// SegAbsImm accesses absolute (sign-extended 32-bit) address disp.  It ignores
+// the type argument.
+func (op MI8) SegAbsImm(text *Buf, seg Segment, _ Type, disp int32, val8 int64) {
+	op.memDispImm(text, seg, true, 0, disp, val8)
+}
+
+func (op MI8) memDispImm(text *Buf, seg Segment, abs bool, base Reg, disp int32, val8 int64) {
 	var o output
+	o.segment(seg)
 	o.rexIf(regRexB(base))
 	o.byte(byte(op >> 8))
-	o.mod(mod, ModRO(op), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, ModRO(op), base, disp)
 	o.int8(int8(val8))
 	o.copy(text.Extend(o.len()))
 }
@@ -611,13 +1473,27 @@ type MI16 uint16 // opcode byte and ModRO byte
 
 // MemDispImm ignores the type argument.
 func (op MI16) MemDispImm(text *Buf, _ Type, base Reg, disp int32, val16 int64) {
-	var mod, dispSize = dispModSize(disp)
+	op.memDispImm(text, NoSegment, false, base, disp, val16)
+}
+
+// SegMemDispImm ignores the type argument.
+func (op MI16) SegMemDispImm(text *Buf, seg Segment, _ Type, base Reg, disp int32, val16 int64) {
+	op.memDispImm(text, seg, false, base, disp, val16)
+}
+
+// SegAbsImm accesses absolute (sign-extended 32-bit) address disp.  It ignores
+// the type argument.
+func (op MI16) SegAbsImm(text *Buf, seg Segment, _ Type, disp int32, val16 int64) {
+	op.memDispImm(text, seg, true, 0, disp, val16)
+}
+
+func (op MI16) memDispImm(text *Buf, seg Segment, abs bool, base Reg, disp int32, val16 int64) {
 	var o output
+	o.segment(seg)
 	o.byte(0x66)
 	o.rexIf(regRexB(base))
 	o.byte(byte(op >> 8))
-	o.mod(mod, ModRO(op), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, ModRO(op), base, disp)
 	o.int16(int16(val16))
 	o.copy(text.Extend(o.len()))
 }
@@ -627,16 +1503,41 @@ func (op MI16) MemDispImm(text *Buf, _ Type, base Reg, disp int32, val16 int64)
 type MI32 uint16 // opcode byte and ModRO byte
 
 func (op MI32) MemDispImm(text *Buf, t Type, base Reg, disp int32, val32 int64) {
-	var mod, dispSize = dispModSize(disp)
+	op.memDispImm(text, NoSegment, false, t, base, disp, val32)
+}
+
+func (op MI32) SegMemDispImm(text *Buf, seg Segment, t Type, base Reg, disp int32, val32 int64) {
+	op.memDispImm(text, seg, false, t, base, disp, val32)
+}
+
+// SegAbsImm accesses absolute (sign-extended 32-bit) address disp.
+func (op MI32) SegAbsImm(text *Buf, seg Segment, t Type, disp int32, val32 int64) {
+	op.memDispImm(text, seg, true, t, 0, disp, val32)
+}
+
+func (op MI32) memDispImm(text *Buf, seg Segment, abs bool, t Type, base Reg, disp int32, val32 int64) {
 	var o output
+	o.segment(seg)
 	o.rexIf(typeRexW(t) | regRexB(base))
 	o.byte(byte(op >> 8))
-	o.mod(mod, ModRO(op), regRM(base))
-	o.int(disp, dispSize)
+	o.memDisp(abs, ModRO(op), base, disp)
 	o.int32(int32(val32))
 	o.copy(text.Extend(o.len()))
 }
 
+// MI with two opcode bytes and 8-bit immediate
+
+type MI2 uint32 // two opcode bytes and ModRO byte
+
+func (op MI2) RegImm8(text *Buf, t Type, r Reg, val int8) {
+	var o output
+	o.rexIf(typeRexW(t) | regRexB(r))
+	o.word(uint16(op >> 8))
+	o.mod(ModReg, ModRO(op), regRM(r))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
 // RMI
 
 type RMI byte // opcode of 8-bit variant, transformed to 32-bit variant automatically
@@ -651,21 +1552,103 @@ func (op RMI) RegRegImm(text *Buf, t Type, r, r2 Reg, val int32) {
 	o.copy(text.Extend(o.len()))
 }
 
+// RMI (MRI) with two opcode bytes and 8-bit immediate
+
+type RMI2 uint16 // two opcode bytes
+
+func (op RMI2) RegRegImm8(text *Buf, t Type, r, r2 Reg, val int8) {
+	var o output
+	o.rexIf(typeRexW(t) | regRexR(r) | regRexB(r2))
+	o.word(uint16(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMI2) RegMemDispImm8(text *Buf, t Type, r, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.rexIf(typeRexW(t) | regRexR(r) | regRexB(base))
+	o.word(uint16(op))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
 // RMI with prefix, two opcode bytes (first byte hardcoded) and size code
 
-type RMIscalar byte // opcode of 8-bit variant, transformed to 32-bit variant automatically
+type RMIscalar byte // second opcode byte; type-dependent third opcode byte
+type RMIpacked byte // second opcode byte; type-dependent third opcode byte
 
 func (op RMIscalar) RegRegImm8(text *Buf, t Type, r, r2 Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, t, r, r, r2, val)
+		return
+	}
 	var o output
 	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.byte(typeRMISizeCode(t))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIscalar) RegMemDispImm8(text *Buf, t Type, r, base Reg, disp int32, val int8) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, t, r, r, base, disp, val)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(base))
 	o.byte(0x0f)
 	o.byte(byte(op))
 	o.byte(typeRMISizeCode(t))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIpacked) RegRegImm8(text *Buf, t Type, r, r2 Reg, val int8) {
+	if text.VEX {
+		op.vexRegRegImm8(text, vexL128, t, r, r2, val)
+		return
+	}
+	var o output
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(r2))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.byte(typeRMIPackedCode(t))
 	o.mod(ModReg, regRO(r), regRM(r2))
 	o.int8(val)
 	o.copy(text.Extend(o.len()))
 }
 
+func (op RMIpacked) RegMemDispImm8(text *Buf, t Type, r, base Reg, disp int32, val int8) {
+	if text.VEX {
+		op.vexRegMemDispImm8(text, vexL128, t, r, base, disp, val)
+		return
+	}
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.byte(0x66)
+	o.rexIf(regRexR(r) | regRexB(base))
+	o.byte(0x0f)
+	o.byte(byte(op))
+	o.byte(typeRMIPackedCode(t))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
 // D
 
 type Db byte    // opcode byte
@@ -794,17 +1777,23 @@ func (op D2d) Stub32(text *Buf) {
 	o.copy(text.Extend(o.len()))
 }
 
+// MissingFunction emits ENDBR64 before the call (and its alignment padding)
+// if text.IBT is set.
 func (op Dd) MissingFunction(text *Buf, align bool) {
 	const insnSize = 5
 
 	var o output
 
+	if text.IBT {
+		ENDBR64.put(&o)
+	}
+
 	if align {
 		// Position of disp must be aligned.
-		if n := (text.Addr + insnSize - 4) & 3; n > 0 {
+		if n := (text.Addr + int32(o.offset) + insnSize - 4) & 3; n > 0 {
 			size := 4 - n
-			copy(o.buf[:size], nops[size][:size])
-			o.offset = uint8(size)
+			copy(o.buf[o.offset:], nops[size][:size])
+			o.offset += uint8(size)
 		}
 	}
 
@@ -0,0 +1,249 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Opmask register and masking mode (EVEX.z and EVEX.aaa)
+type Mask byte
+
+const (
+	NoMask   = Mask(0)
+	MaskZero = Mask(1 << 7) // zeroing-masking; merging-masking otherwise
+)
+
+// KMask uses opmask register k1..k7 for merging-masking.  Combine with
+// MaskZero for zeroing-masking.
+func KMask(k Reg) Mask { return Mask(k & 7) }
+
+type evexRXBR byte // EVEX.R, EVEX.X, EVEX.B and EVEX.R' at their bit positions (not inverted)
+
+// Vector registers 0..31 are encoded using the REX-equivalent bits and an
+// additional high bit.
+
+func regEvexR(r Reg) evexRXBR     { return evexRXBR(r&8)<<4 | evexRXBR(r&16) }    // ModRM reg: R and R'
+func regEvexB(r Reg) evexRXBR     { return evexRXBR(r&8)<<2 | evexRXBR(r&16)<<2 } // ModRM rm: B and X
+func regEvexBase(r Reg) evexRXBR  { return evexRXBR(r&8) << 2 }                   // B
+func regEvexIndex(r Reg) evexRXBR { return evexRXBR(r&8) << 3 }                   // X
+
+// widthEvexLL reports an error if the width cannot be encoded with EVEX
+// prefix.
+func widthEvexLL(text *Buf, w Width) (ll byte, ok bool) {
+	switch w {
+	case Width128:
+		return 0 << 5, true
+
+	case Width256:
+		return 1 << 5, true
+
+	case Width512:
+		return 2 << 5, true
+
+	default:
+		text.Err(errors.Errorf("missing EVEX encoding for width=%v addr=%v", w, text.Addr))
+		return
+	}
+}
+
+// evex appends a 4-byte EVEX prefix.
+func (o *output) evex(m vexMap, w bool, rxbr evexRXBR, v Reg, ll byte, bcst bool, mask Mask, pp vexPP) {
+	o.byte(0x62)
+	o.byte(byte(^rxbr&0xf0) | byte(m))
+	o.byte(bit(w)<<7 | byte(^v&15)<<3 | 1<<2 | byte(pp))
+	o.byte(byte(mask&MaskZero) | ll | bit(bcst)<<4 | byte(^v&16)>>1 | byte(mask&7))
+}
+
+// evexDispModSize is like dispModSize, but 8-bit displacement is scaled by n
+// (the compressed disp8*N encoding).
+func evexDispModSize(disp, n int32) (mod Mod, size uint8, value int32) {
+	switch {
+	case disp == 0:
+		return ModMem, 0, 0
+
+	case disp%n == 0 && uint32(disp/n+128) <= 255:
+		return ModMemDisp8, 1, disp / n
+
+	default:
+		return ModMemDisp32, 4, disp
+	}
+}
+
+func checkMask(text *Buf, m Mask) bool {
+	if m == MaskZero {
+		text.Err(errors.Errorf("zeroing-masking without opmask register addr=%v", text.Addr))
+		return false
+	}
+	return true
+}
+
+// RM with EVEX prefix
+
+type RMevex uint32  // broadcast and W bits, fixed-length prefix, escape byte (0, 0x38 or 0x3a) and opcode byte
+type RMIevex uint32 // like RMevex; imm8
+type MIevex uint32  // W bit, fixed-length prefix, opcode byte and ModRO byte; imm8
+
+const evexBcst = 2 << 24 // embedded broadcast is supported
+
+func (op RMevex) w() bool      { return op>>24&1 != 0 }
+func (op RMevex) bcst() bool   { return op&evexBcst != 0 }
+func (op RMevex) pp() vexPP    { return prefixVexPP(byte(op >> 16)) }
+func (op RMevex) opcode() byte { return byte(op) }
+
+func (op RMevex) vexMap() vexMap {
+	switch byte(op >> 8) {
+	case 0x38:
+		return vexMap0F38
+
+	case 0x3a:
+		return vexMap0F3A
+
+	default:
+		return vexMap0F
+	}
+}
+
+// memN is the scaling factor of compressed 8-bit displacement.
+func (op RMevex) memN(w Width, bcst bool) int32 {
+	if bcst {
+		return 4 << bit(op.w())
+	}
+	if op.vexMap() == vexMap0F {
+		switch op.opcode() {
+		case 0xd1, 0xd2, 0xd3, 0xe1, 0xe2, 0xf1, 0xf2, 0xf3:
+			return 16 // shift count is always a 128-bit operand
+		}
+	}
+	return int32(w)
+}
+
+// checkBcst reports an error if the instruction has no embedded broadcast
+// form.
+func (op RMevex) checkBcst(text *Buf, family string) bool {
+	if !op.bcst() {
+		text.Err(errors.Errorf("missing encoding for %s op=%x broadcast addr=%v", family, uint32(op), text.Addr))
+		return false
+	}
+	return true
+}
+
+func (op RMevex) regRegReg(o *output, ll byte, m Mask, r, r1, r2 Reg) {
+	o.evex(op.vexMap(), op.w(), regEvexR(r)|regEvexB(r2), r1, ll, false, m, op.pp())
+	o.byte(op.opcode())
+	o.mod(ModReg, regRO(r), regRM(r2))
+}
+
+func (op RMevex) regRegMemDisp(o *output, w Width, ll byte, m Mask, r, r1, base Reg, disp int32, bcst bool) {
+	var mod, dispSize, dispValue = evexDispModSize(disp, op.memN(w, bcst))
+	o.evex(op.vexMap(), op.w(), regEvexR(r)|regEvexBase(base), r1, ll, bcst, m, op.pp())
+	o.byte(op.opcode())
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(dispValue, dispSize)
+}
+
+func (op RMevex) RegRegReg(text *Buf, w Width, m Mask, r, r1, r2 Reg) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) {
+		var o output
+		op.regRegReg(&o, ll, m, r, r1, r2)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+func (op RMevex) RegRegMemDisp(text *Buf, w Width, m Mask, r, r1, base Reg, disp int32) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) {
+		var o output
+		op.regRegMemDisp(&o, w, ll, m, r, r1, base, disp, false)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// RegRegBcstDisp broadcasts a 32-bit or 64-bit element (depending on EVEX.W)
+// from memory.
+func (op RMevex) RegRegBcstDisp(text *Buf, w Width, m Mask, r, r1, base Reg, disp int32) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) && op.checkBcst(text, "RMevex") {
+		var o output
+		op.regRegMemDisp(&o, w, ll, m, r, r1, base, disp, true)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+func (op RMIevex) RegRegRegImm8(text *Buf, w Width, m Mask, r, r1, r2 Reg, val int8) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) {
+		var o output
+		RMevex(op).regRegReg(&o, ll, m, r, r1, r2)
+		o.int8(val)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+func (op RMIevex) RegRegMemDispImm8(text *Buf, w Width, m Mask, r, r1, base Reg, disp int32, val int8) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) {
+		var o output
+		RMevex(op).regRegMemDisp(&o, w, ll, m, r, r1, base, disp, false)
+		o.int8(val)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// RegRegBcstDispImm8 broadcasts a 32-bit or 64-bit element (depending on
+// EVEX.W) from memory.
+func (op RMIevex) RegRegBcstDispImm8(text *Buf, w Width, m Mask, r, r1, base Reg, disp int32, val int8) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) && RMevex(op).checkBcst(text, "RMIevex") {
+		var o output
+		RMevex(op).regRegMemDisp(&o, w, ll, m, r, r1, base, disp, true)
+		o.int8(val)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// RegRegImm8 encodes destination r and source operand r2.
+func (op MIevex) RegRegImm8(text *Buf, w Width, m Mask, r, r2 Reg, val int8) {
+	if ll, ok := widthEvexLL(text, w); ok && checkMask(text, m) {
+		var o output
+		o.evex(vexMap0F, op>>24&1 != 0, regEvexB(r2), r, ll, false, m, prefixVexPP(byte(op>>16)))
+		o.byte(byte(op >> 8))
+		o.mod(ModReg, ModRO(op), regRM(r2))
+		o.int8(val)
+		o.copy(text.Extend(o.len()))
+	}
+}
+
+// Opmask register moves
+
+type Kmov byte // opcode byte
+
+// RegReg moves between an opmask register and a general-purpose register.
+// The operand size determines the instruction variant (KMOVB, KMOVW, KMOVD or
+// KMOVQ).
+func (op Kmov) RegReg(text *Buf, sz Size, r, r2 Reg) {
+	var pp vexPP
+	var wrxb rexWRXB
+
+	switch sz {
+	case Byte:
+		pp = vexPP66
+
+	case Word:
+		pp = vexPPNone
+
+	case Long:
+		pp = vexPPF2
+
+	case Quad:
+		pp = vexPPF2
+		wrxb = RexW
+
+	default:
+		text.Err(errors.Errorf("missing encoding for Kmov op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+
+	var o output
+	o.vex(vexMap0F, wrxb|regRexR(r)|regRexB(r2), vexNoReg, vexL128, pp)
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
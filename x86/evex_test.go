@@ -0,0 +1,163 @@
+package in
+
+import (
+	"fmt"
+	"github.com/tsavola/wag/buffer"
+	"golang.org/x/arch/x86/x86asm"
+	"testing"
+)
+
+func TestEVEXInstructions(t *testing.T) {
+	for i := 0; i <= 31; i++ {
+		for j := 0; j <= 31; j++ {
+			k := (i + j + 1) & 31
+			zi := fmt.Sprintf("Z%d", i)
+			zj := fmt.Sprintf("Z%d", j)
+			zk := fmt.Sprintf("Z%d", k)
+			yi := fmt.Sprintf("Y%d", i)
+			yj := fmt.Sprintf("Y%d", j)
+			yk := fmt.Sprintf("Y%d", k)
+			xi := fmt.Sprintf("X%d", i)
+			xj := fmt.Sprintf("X%d", j)
+			xk := fmt.Sprintf("X%d", k)
+			ri, rj, rk := Reg(i), Reg(j), Reg(k)
+
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegReg(text, Width512, NoMask, ri, rk, rj) }),
+				x86asm.VPMULLQ, zi, zk, zj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegReg(text, Width256, NoMask, ri, rk, rj) }),
+				x86asm.VPMULLQ, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegReg(text, Width128, NoMask, ri, rk, rj) }),
+				x86asm.VPMULLQ, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPSRAQ.RegRegReg(text, Width512, NoMask, ri, rk, rj) }),
+				x86asm.VPSRAQ, zi, zk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPSRAQ.RegRegReg(text, Width128, NoMask, ri, rk, rj) }),
+				x86asm.VPSRAQ, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPSRAQi.RegRegImm8(text, Width128, NoMask, ri, rj, 63) }),
+				x86asm.VPSRAQ, xi, xj, "0x3f")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPSRAQi.RegRegImm8(text, Width512, NoMask, ri, rj, 1) }),
+				x86asm.VPSRAQ, zi, zj, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPERMB.RegRegReg(text, Width512, NoMask, ri, rk, rj) }),
+				x86asm.VPERMB, zi, zk, zj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPTERNLOGD.RegRegRegImm8(text, Width128, NoMask, ri, rk, rj, 0x55) }),
+				x86asm.VPTERNLOGD, xi, xk, xj, "0x55")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPTERNLOGQ.RegRegRegImm8(text, Width256, NoMask, ri, rk, rj, -0x80) }),
+				x86asm.VPTERNLOGQ, yi, yk, yj, "0x80")
+		}
+	}
+
+	for k := 1; k <= 7; k++ {
+		kk := fmt.Sprintf("K%d", k)
+
+		for _, zero := range []bool{false, true} {
+			m := KMask(Reg(k))
+			if zero {
+				m |= MaskZero
+			}
+
+			inst := encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegReg(text, Width512, m, 1, 2, 3) })
+			checkTestInst(t, inst, x86asm.VPMULLQ, "Z1", kk, "Z2", "Z3")
+			if inst.Zeroing != zero {
+				t.Errorf("Zeroing=%v", inst.Zeroing)
+			}
+
+			inst = encodeTestInst(t, func(text *Buf) { VPERMB.RegRegMemDisp(text, Width256, m, 1, 2, 3, 0x40) })
+			checkTestInst(t, inst, x86asm.VPERMB, "Y1", kk, "Y2", "[RBX+0x40]")
+			if inst.Zeroing != zero {
+				t.Errorf("Zeroing=%v", inst.Zeroing)
+			}
+		}
+	}
+
+	for i := 0; i <= 31; i++ {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			k := (i + base + 1) & 31
+			zi := fmt.Sprintf("Z%d", i)
+			zk := fmt.Sprintf("Z%d", k)
+			xi := fmt.Sprintf("X%d", i)
+			xk := fmt.Sprintf("X%d", k)
+			ri, rk, rb := Reg(i), Reg(k), Reg(base)
+
+			for _, disp := range []int32{0, 1, 4, 8, 16, 0x40, -0x40, 0x80, 0x1fc0, 0x2000, -0x2000, -0x2040, 0x12345} {
+				var m string
+				switch {
+				case disp > 0:
+					m = fmt.Sprintf("[%s+%#x]", testGPRegs64[base], disp)
+				case disp < 0:
+					m = fmt.Sprintf("[%s-%#x]", testGPRegs64[base], -disp)
+				default:
+					m = fmt.Sprintf("[%s]", testGPRegs64[base])
+				}
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegMemDisp(text, Width512, NoMask, ri, rk, rb, disp) }),
+					x86asm.VPMULLQ, zi, zk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegMemDisp(text, Width128, NoMask, ri, rk, rb, disp) }),
+					x86asm.VPMULLQ, xi, xk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { VPSRAQ.RegRegMemDisp(text, Width512, NoMask, ri, rk, rb, disp) }),
+					x86asm.VPSRAQ, zi, zk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) {
+					VPTERNLOGD.RegRegMemDispImm8(text, Width512, NoMask, ri, rk, rb, disp, 0x55)
+				}), x86asm.VPTERNLOGD, zi, zk, m, "0x55")
+
+				inst := encodeTestInst(t, func(text *Buf) { VPMULLQ.RegRegBcstDisp(text, Width512, NoMask, ri, rk, rb, disp) })
+				checkTestInst(t, inst, x86asm.VPMULLQ, zi, zk, m)
+				if !inst.Broadcast || inst.MemBytes != 8 {
+					t.Errorf("Broadcast=%v MemBytes=%v", inst.Broadcast, inst.MemBytes)
+				}
+
+				inst = encodeTestInst(t, func(text *Buf) {
+					VPTERNLOGD.RegRegBcstDispImm8(text, Width512, NoMask, ri, rk, rb, disp, 0x55)
+				})
+				checkTestInst(t, inst, x86asm.VPTERNLOGD, zi, zk, m, "0x55")
+				if !inst.Broadcast || inst.MemBytes != 4 {
+					t.Errorf("Broadcast=%v MemBytes=%v", inst.Broadcast, inst.MemBytes)
+				}
+			}
+		}
+	}
+
+	for k := 0; k <= 7; k++ {
+		for r := 0; r <= 15; r++ {
+			kk := fmt.Sprintf("K%d", k)
+
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { KMOV.RegReg(text, Word, Reg(k), Reg(r)) }), x86asm.KMOVW, kk, testGPRegs32[r])
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { KMOV.RegReg(text, Quad, Reg(k), Reg(r)) }), x86asm.KMOVQ, kk, testGPRegs64[r])
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { KMOVmr.RegReg(text, Byte, Reg(r), Reg(k)) }), x86asm.KMOVB, testGPRegs32[r], kk)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { KMOVmr.RegReg(text, Long, Reg(r), Reg(k)) }), x86asm.KMOVD, testGPRegs32[r], kk)
+		}
+	}
+}
+
+func TestEVEXDispModSize(t *testing.T) {
+	for _, x := range [][5]int32{
+		{0, 64, int32(ModMem), 0, 0},
+		{64, 64, int32(ModMemDisp8), 1, 1},
+		{-128 * 64, 64, int32(ModMemDisp8), 1, -128},
+		{127 * 64, 64, int32(ModMemDisp8), 1, 127},
+		{128 * 64, 64, int32(ModMemDisp32), 4, 128 * 64},
+		{-129 * 64, 64, int32(ModMemDisp32), 4, -129 * 64},
+		{1, 64, int32(ModMemDisp32), 4, 1},
+		{-8, 8, int32(ModMemDisp8), 1, -1},
+		{12, 8, int32(ModMemDisp32), 4, 12},
+	} {
+		mod, size, value := evexDispModSize(x[0], x[1])
+		if mod != Mod(x[2]) || size != uint8(x[3]) || value != x[4] {
+			t.Errorf("evexDispModSize(%d, %d) = %d, %d, %d", x[0], x[1], mod, size, value)
+		}
+	}
+}
+
+func TestEVEXErrors(t *testing.T) {
+	for _, fn := range []func(*Buf){
+		func(text *Buf) { VPMULLQ.RegRegReg(text, Width(8), NoMask, 0, 1, 2) },
+		func(text *Buf) { VPMULLQ.RegRegReg(text, Width512, MaskZero, 0, 1, 2) },
+		func(text *Buf) { KMOV.RegReg(text, Octet, 0, 1) },
+		func(text *Buf) { VPERMB.RegRegBcstDisp(text, Width512, NoMask, 0, 1, 2, 0x40) },
+		func(text *Buf) { VPSRAQ.RegRegBcstDisp(text, Width512, NoMask, 0, 1, 2, 0x40) },
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		fn(text)
+		if len(text.Errors) != 1 || len(text.Bytes()) != 0 {
+			t.Errorf("errors=%v bytes=%x", text.Errors, text.Bytes())
+		}
+	}
+}
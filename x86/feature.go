@@ -0,0 +1,30 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+// CPU feature required by an instruction beyond the x86-64 baseline
+type Feature uint8
+
+const (
+	FeatureBMI1 = Feature(1)
+	FeatureBMI2 = Feature(2)
+	FeatureFMA  = Feature(3)
+)
+
+func (f Feature) String() string {
+	switch f {
+	case FeatureBMI1:
+		return "bmi1"
+
+	case FeatureBMI2:
+		return "bmi2"
+
+	case FeatureFMA:
+		return "fma"
+
+	default:
+		return "<invalid feature>"
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+// FMA3 instructions with VEX prefix.  The destination r is also the first
+// operand; the digits of the mnemonic specify the order of r, r1 and r2 (or
+// the memory operand) in the multiplication and addition.  VEX.W selects
+// double precision.
+
+type FMAscalar byte // opcode byte (0x38-escaped); 0x66 prefix
+type FMApacked byte // opcode byte (0x38-escaped); 0x66 prefix
+
+func (op FMAscalar) Feature() Feature { return FeatureFMA }
+func (op FMApacked) Feature() Feature { return FeatureFMA }
+
+func fmaRegRegReg(text *Buf, op byte, l vexL, t Type, r, r1, r2 Reg) {
+	var o output
+	o.vex(vexMap0F38, typeRexW(t)|regRexR(r)|regRexB(r2), r1, l, vexPP66)
+	o.byte(op)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func fmaRegRegMemDisp(text *Buf, op byte, l vexL, t Type, r, r1, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(vexMap0F38, typeRexW(t)|regRexR(r)|regRexB(base), r1, l, vexPP66)
+	o.byte(op)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op FMAscalar) RegRegReg(text *Buf, t Type, r, r1, r2 Reg) {
+	fmaRegRegReg(text, byte(op), vexL128, t, r, r1, r2)
+}
+
+func (op FMAscalar) RegRegMemDisp(text *Buf, t Type, r, r1, base Reg, disp int32) {
+	fmaRegRegMemDisp(text, byte(op), vexL128, t, r, r1, base, disp)
+}
+
+func (op FMApacked) RegRegReg(text *Buf, w Width, t Type, r, r1, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		fmaRegRegReg(text, byte(op), l, t, r, r1, r2)
+	}
+}
+
+func (op FMApacked) RegRegMemDisp(text *Buf, w Width, t Type, r, r1, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		fmaRegRegMemDisp(text, byte(op), l, t, r, r1, base, disp)
+	}
+}
@@ -0,0 +1,647 @@
+package in
+
+import (
+	"encoding/hex"
+	"github.com/tsavola/wag/buffer"
+	"golang.org/x/arch/x86/x86asm"
+	"testing"
+)
+
+var testGPRegs8 = [16]string{
+	"AL", "CL", "DL", "BL", "SPB", "BPB", "SIB", "DIB",
+	"R8B", "R9B", "R10B", "R11B", "R12B", "R13B", "R14B", "R15B",
+}
+
+var testGPRegs16 = [16]string{
+	"AX", "CX", "DX", "BX", "SP", "BP", "SI", "DI",
+	"R8W", "R9W", "R10W", "R11W", "R12W", "R13W", "R14W", "R15W",
+}
+
+var testGPRegSizes = []struct {
+	sz   Size
+	regs *[16]string
+}{
+	{Byte, &testGPRegs8},
+	{Word, &testGPRegs16},
+	{Long, &testGPRegs32},
+	{Quad, &testGPRegs64},
+}
+
+func testLocked(inst x86asm.Inst) bool {
+	for _, p := range inst.Prefix {
+		if p&0xff == x86asm.PrefixLOCK {
+			return true
+		}
+	}
+	return false
+}
+
+func testRepeated(inst x86asm.Inst) bool {
+	for _, p := range inst.Prefix {
+		if p&0xff == x86asm.PrefixREP {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAtomicInstructions(t *testing.T) {
+	checkLocked := func(inst x86asm.Inst, lock bool) {
+		t.Helper()
+		if testLocked(inst) != lock {
+			t.Errorf("Expected lock=%v, found prefixes %v", lock, inst.Prefix)
+		}
+	}
+
+	for _, x := range testGPRegSizes {
+		for i := 0; i <= 15; i++ {
+			for j := 0; j <= 15; j++ {
+				sz, ri, rj := x.sz, Reg(i), Reg(j)
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { XCHG.RegReg(text, sz, ri, rj) }), x86asm.XCHG, x.regs[j], x.regs[i])
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPXCHG.RegReg(text, sz, ri, rj) }), x86asm.CMPXCHG, x.regs[j], x.regs[i])
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { XADD.RegReg(text, sz, ri, rj) }), x86asm.XADD, x.regs[j], x.regs[i])
+			}
+		}
+
+		for i := 0; i <= 15; i++ {
+			for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+				index := (base + 7) & 15 // never RSP
+				sz, r, b := x.sz, Reg(i), Reg(base)
+
+				for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+					m := "[" + testGPRegs64[base] + dispStr + "]"
+					mi := "[" + testGPRegs64[base] + "+4*" + testGPRegs64[index] + dispStr + "]"
+
+					for _, lock := range []bool{false, true} {
+						inst := encodeTestInst(t, func(text *Buf) { XCHG.RegMemDisp(text, lock, sz, r, b, disp) })
+						checkTestInst(t, inst, x86asm.XCHG, m, x.regs[i])
+						checkLocked(inst, lock)
+
+						inst = encodeTestInst(t, func(text *Buf) { CMPXCHG.RegMemDisp(text, lock, sz, r, b, disp) })
+						checkTestInst(t, inst, x86asm.CMPXCHG, m, x.regs[i])
+						checkLocked(inst, lock)
+
+						inst = encodeTestInst(t, func(text *Buf) { XADD.RegMemDisp(text, lock, sz, r, b, disp) })
+						checkTestInst(t, inst, x86asm.XADD, m, x.regs[i])
+						checkLocked(inst, lock)
+
+						inst = encodeTestInst(t, func(text *Buf) { CMPXCHG.RegMemIndexDisp(text, lock, sz, r, b, Reg(index), Scale2, disp) })
+						checkTestInst(t, inst, x86asm.CMPXCHG, mi, x.regs[i])
+						checkLocked(inst, lock)
+
+						inst = encodeTestInst(t, func(text *Buf) { XADD.RegMemIndexDisp(text, lock, sz, r, b, Reg(index), Scale2, disp) })
+						checkTestInst(t, inst, x86asm.XADD, mi, x.regs[i])
+						checkLocked(inst, lock)
+
+						if sz == Quad {
+							inst = encodeTestInst(t, func(text *Buf) { CMPXCHG16B.MemDisp(text, lock, b, disp) })
+							checkTestInst(t, inst, x86asm.CMPXCHG16B, m)
+							checkLocked(inst, lock)
+
+							inst = encodeTestInst(t, func(text *Buf) { CMPXCHG16B.MemIndexDisp(text, lock, b, Reg(index), Scale2, disp) })
+							checkTestInst(t, inst, x86asm.CMPXCHG16B, mi)
+							checkLocked(inst, lock)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	checkTestInst(t, encodeTestInst(t, func(text *Buf) { LFENCE.Simple(text) }), x86asm.LFENCE)
+	checkTestInst(t, encodeTestInst(t, func(text *Buf) { MFENCE.Simple(text) }), x86asm.MFENCE)
+	checkTestInst(t, encodeTestInst(t, func(text *Buf) { SFENCE.Simple(text) }), x86asm.SFENCE)
+}
+
+func TestAtomicErrors(t *testing.T) {
+	for _, fn := range []func(*Buf){
+		func(text *Buf) { XCHG.RegReg(text, Octet, 0, 1) },
+		func(text *Buf) { CMPXCHG.RegMemDisp(text, true, Size(3), 0, 1, 0) },
+		func(text *Buf) { XADD.RegMemIndexDisp(text, true, Octet, 0, 1, 2, Scale0, 0) },
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		fn(text)
+		if len(text.Errors) != 1 || len(text.Bytes()) != 0 {
+			t.Errorf("errors=%v bytes=%x", text.Errors, text.Bytes())
+		}
+	}
+}
+
+// BMI instructions are not supported by the disassembler, so the encodings
+// are compared against assembler output.
+func TestBMIInstructions(t *testing.T) {
+	for _, x := range []struct {
+		fn   func(*Buf)
+		code string
+	}{
+		{func(text *Buf) { ANDN.RegRegReg(text, I32, 0, 3, 1) }, "c4e260f2c1"},
+		{func(text *Buf) { ANDN.RegRegReg(text, I64, 11, 10, 9) }, "c442a8f2d9"},
+		{func(text *Buf) { ANDN.RegRegMemDisp(text, I64, 0, 10, 3, 0x10) }, "c4e2a8f24310"},
+		{func(text *Buf) { ANDN.RegRegMemDisp(text, I32, 15, 3, 9, -0x1000) }, "c44260f2b900f0ffff"},
+		{func(text *Buf) { BLSR.RegReg(text, I32, 0, 1) }, "c4e278f3c9"},
+		{func(text *Buf) { BLSR.RegReg(text, I64, 11, 9) }, "c4c2a0f3c9"},
+		{func(text *Buf) { BLSMSK.RegReg(text, I64, 8, 2) }, "c4e2b8f3d2"},
+		{func(text *Buf) { BLSI.RegMemDisp(text, I64, 10, 3, 0x10) }, "c4e2a8f35b10"},
+		{func(text *Buf) { BZHI.RegRegReg(text, I32, 0, 3, 1) }, "c4e270f5c3"},
+		{func(text *Buf) { BZHI.RegRegReg(text, I64, 10, 9, 11) }, "c442a0f5d1"},
+		{func(text *Buf) { PDEP.RegRegReg(text, I64, 0, 3, 1) }, "c4e2e3f5c1"},
+		{func(text *Buf) { PEXT.RegRegReg(text, I32, 11, 10, 9) }, "c4422af5d9"},
+		{func(text *Buf) { SHLX.RegRegReg(text, I32, 0, 3, 1) }, "c4e271f7c3"},
+		{func(text *Buf) { SHRX.RegRegReg(text, I64, 10, 9, 11) }, "c442a3f7d1"},
+		{func(text *Buf) { SARX.RegMemDispReg(text, I64, 0, 3, 0x10, 1) }, "c4e2f2f74310"},
+		{func(text *Buf) { RORX.RegRegImm8(text, I32, 0, 1, 7) }, "c4e37bf0c107"},
+		{func(text *Buf) { RORX.RegRegImm8(text, I64, 10, 9, 63) }, "c443fbf0d13f"},
+		{func(text *Buf) { RORX.RegMemDispImm8(text, I64, 10, 9, -0x1000, 1) }, "c443fbf09100f0ffff01"},
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		x.fn(text)
+		if len(text.Errors) != 0 {
+			t.Errorf("%s: %v", x.code, text.Errors)
+		} else if code := hex.EncodeToString(text.Bytes()); code != x.code {
+			t.Errorf("Expected %s, found %s", x.code, code)
+		}
+	}
+
+	for _, f := range []Feature{ANDN.Feature(), BLSR.Feature(), BLSMSK.Feature(), BLSI.Feature()} {
+		if f != FeatureBMI1 {
+			t.Errorf("Expected %v, found %v", FeatureBMI1, f)
+		}
+	}
+	for _, f := range []Feature{BZHI.Feature(), PDEP.Feature(), PEXT.Feature(), SHLX.Feature(), SHRX.Feature(), SARX.Feature(), RORX.Feature()} {
+		if f != FeatureBMI2 {
+			t.Errorf("Expected %v, found %v", FeatureBMI2, f)
+		}
+	}
+}
+
+func TestWideArithmeticInstructions(t *testing.T) {
+	for _, x := range []struct {
+		t    Type
+		regs *[16]string
+	}{
+		{I32, &testGPRegs32},
+		{I64, &testGPRegs64},
+	} {
+		for i := 0; i <= 15; i++ {
+			ri, si := Reg(i), x.regs[i]
+
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADCi.RegImm(text, x.t, ri, 1) }), x86asm.ADC, si, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SBBi.RegImm(text, x.t, ri, 0x1000) }), x86asm.SBB, si, "0x1000")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MUL.Reg(text, x.t, ri) }), x86asm.MUL, si)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { IMUL1.Reg(text, x.t, ri) }), x86asm.IMUL, si)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BSWAP.Reg(text, x.t, ri) }), x86asm.BSWAP, si)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTi.RegImm8(text, x.t, ri, 5) }), x86asm.BT, si, "0x5")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTSi.RegImm8(text, x.t, ri, 31) }), x86asm.BTS, si, "0x1f")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTRi.RegImm8(text, x.t, ri, 0) }), x86asm.BTR, si, "0x0")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTCi.RegImm8(text, x.t, ri, 1) }), x86asm.BTC, si, "0x1")
+
+			for j := 0; j <= 15; j++ {
+				rj, sj := Reg(j), x.regs[j]
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADC.RegReg(text, x.t, ri, rj) }), x86asm.ADC, si, sj)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SBB.RegReg(text, x.t, ri, rj) }), x86asm.SBB, si, sj)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHLD.RegReg(text, x.t, ri, rj) }), x86asm.SHLD, sj, si, "CL")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHRD.RegReg(text, x.t, ri, rj) }), x86asm.SHRD, sj, si, "CL")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHLDi.RegRegImm8(text, x.t, ri, rj, 7) }), x86asm.SHLD, sj, si, "0x7")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHRDi.RegRegImm8(text, x.t, ri, rj, 63) }), x86asm.SHRD, sj, si, "0x3f")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BT.RegReg(text, x.t, ri, rj) }), x86asm.BT, sj, si)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTS.RegReg(text, x.t, ri, rj) }), x86asm.BTS, sj, si)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTR.RegReg(text, x.t, ri, rj) }), x86asm.BTR, sj, si)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BTC.RegReg(text, x.t, ri, rj) }), x86asm.BTC, sj, si)
+			}
+
+			for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+				b := Reg(base)
+
+				for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+					m := "[" + testGPRegs64[base] + dispStr + "]"
+
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADC.RegMemDisp(text, x.t, ri, b, disp) }), x86asm.ADC, si, m)
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { SBB.RegMemDisp(text, x.t, ri, b, disp) }), x86asm.SBB, si, m)
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHLDi.RegMemDispImm8(text, x.t, ri, b, disp, 1) }), x86asm.SHLD, m, si, "0x1")
+				}
+			}
+		}
+	}
+}
+
+func TestStringInstructions(t *testing.T) {
+	for _, x := range []struct {
+		fn  func(*Buf)
+		op  x86asm.Op
+		rep bool
+	}{
+		{func(text *Buf) { MOVSB.Simple(text) }, x86asm.MOVSB, false},
+		{func(text *Buf) { MOVS.Type(text, I32) }, x86asm.MOVSD, false},
+		{func(text *Buf) { MOVS.Type(text, I64) }, x86asm.MOVSQ, false},
+		{func(text *Buf) { STOSB.Simple(text) }, x86asm.STOSB, false},
+		{func(text *Buf) { STOS.Type(text, I32) }, x86asm.STOSD, false},
+		{func(text *Buf) { STOS.Type(text, I64) }, x86asm.STOSQ, false},
+		{func(text *Buf) { REPMOVSB.Simple(text) }, x86asm.MOVSB, true},
+		{func(text *Buf) { REPMOVS.Type(text, I32) }, x86asm.MOVSD, true},
+		{func(text *Buf) { REPMOVS.Type(text, I64) }, x86asm.MOVSQ, true},
+		{func(text *Buf) { REPSTOSB.Simple(text) }, x86asm.STOSB, true},
+		{func(text *Buf) { REPSTOS.Type(text, I32) }, x86asm.STOSD, true},
+		{func(text *Buf) { REPSTOS.Type(text, I64) }, x86asm.STOSQ, true},
+		{func(text *Buf) { CLD.Simple(text) }, x86asm.CLD, false},
+		{func(text *Buf) { STD.Simple(text) }, x86asm.STD, false},
+	} {
+		inst := encodeTestInst(t, x.fn)
+		checkTestInst(t, inst, x.op)
+		if testRepeated(inst) != x.rep {
+			t.Errorf("%v: expected rep=%v, found prefixes %v", x.op, x.rep, inst.Prefix)
+		}
+	}
+}
+
+func TestIndirectBranchInstructions(t *testing.T) {
+	for i := 0; i <= 15; i++ {
+		r := Reg(i)
+
+		checkTestInst(t, encodeTestInst(t, func(text *Buf) { CALL.Reg(text, r) }), x86asm.CALL, testGPRegs64[i])
+		checkTestInst(t, encodeTestInst(t, func(text *Buf) { JMP.Reg(text, r) }), x86asm.JMP, testGPRegs64[i])
+	}
+
+	for _, x := range []struct {
+		fn   func(*Buf)
+		code string
+	}{
+		{func(text *Buf) { CALL.Reg(text, 0) }, "ffd0"},
+		{func(text *Buf) { CALL.Reg(text, 11) }, "41ffd3"},
+		{func(text *Buf) { JMP.MemIndexDisp(text, 1, 9, Scale3, 0) }, "42ff24c9"},
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		x.fn(text)
+		if code := hex.EncodeToString(text.Bytes()); code != x.code {
+			t.Errorf("Expected %s, found %s", x.code, code)
+		}
+	}
+
+	for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+		for _, index := range []int{0, 1, 3, 7, 8, 9, 13, 15} {
+			b, x := Reg(base), Reg(index)
+
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+				mi := "[" + testGPRegs64[base] + "+8*" + testGPRegs64[index] + dispStr + "]"
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CALL.MemDisp(text, b, disp) }), x86asm.CALL, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { JMP.MemDisp(text, b, disp) }), x86asm.JMP, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CALL.MemIndexDisp(text, b, x, Scale3, disp) }), x86asm.CALL, mi)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { JMP.MemIndexDisp(text, b, x, Scale3, disp) }), x86asm.JMP, mi)
+			}
+		}
+	}
+}
+
+func TestBranchTargetInstructions(t *testing.T) {
+	text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+	ENDBR64.Simple(text)
+	if code := hex.EncodeToString(text.Bytes()); code != "f30f1efa" {
+		t.Errorf("ENDBR64: %s", code)
+	}
+
+	for _, ibt := range []bool{false, true} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32), Addr: 0x100, IBT: ibt}
+		if addr := text.BranchTarget(); addr != 0x100 {
+			t.Errorf("BranchTarget address: %#x", addr)
+		}
+		expected := ""
+		if ibt {
+			expected = "f30f1efa"
+		}
+		if code := hex.EncodeToString(text.Bytes()); code != expected {
+			t.Errorf("BranchTarget with IBT=%v: %s", ibt, code)
+		}
+	}
+}
+
+func TestMissingFunction(t *testing.T) {
+	for _, ibt := range []bool{false, true} {
+		for _, align := range []bool{false, true} {
+			for start := int32(0x100); start < 0x108; start++ {
+				text := &Buf{Buffer: buffer.NewLimited(nil, 32), Addr: start, IBT: ibt}
+				CALLcd.MissingFunction(text, align)
+				code := text.Bytes()
+
+				if text.Addr != start+int32(len(code)) {
+					t.Errorf("Addr=%#x after %d bytes from %#x", text.Addr, len(code), start)
+				}
+
+				offset := 0
+				if ibt {
+					if len(code) < 4 || hex.EncodeToString(code[:4]) != "f30f1efa" {
+						t.Errorf("IBT: %x", code)
+						continue
+					}
+					offset = 4
+				}
+
+				callOffset := len(code) - 5
+				if !align && callOffset != offset {
+					t.Errorf("Unexpected padding: %x", code)
+				}
+				if code[callOffset] != 0xe8 {
+					t.Errorf("Call not found: %x", code)
+					continue
+				}
+
+				dispAddr := start + int32(callOffset) + 1
+				if align && dispAddr&3 != 0 {
+					t.Errorf("Unaligned disp at %#x (IBT=%v): %x", dispAddr, ibt, code)
+				}
+
+				disp := int32(code[callOffset+1]) | int32(code[callOffset+2])<<8 | int32(code[callOffset+3])<<16 | int32(code[callOffset+4])<<24
+				if dispAddr+4+disp != 0 {
+					t.Errorf("Call target %#x", dispAddr+4+disp)
+				}
+			}
+		}
+	}
+}
+
+func TestMemImmInstructions(t *testing.T) {
+	for _, sz := range []Size{Byte, Word, Long, Quad} {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			b := Reg(base)
+
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+
+				for _, x := range []struct {
+					op   MI
+					insn x86asm.Op
+				}{
+					{ADDi, x86asm.ADD},
+					{ORi, x86asm.OR},
+					{ADCi, x86asm.ADC},
+					{SBBi, x86asm.SBB},
+					{ANDi, x86asm.AND},
+					{SUBi, x86asm.SUB},
+					{XORi, x86asm.XOR},
+					{CMPi, x86asm.CMP},
+				} {
+					vals := map[int32]string{0: "0x0", 1: "0x1", -1: "-0x1", 0x7f: "0x7f", -0x80: "-0x80", 0x1000: "0x1000", -0x1000: "-0x1000"}
+					if sz == Byte {
+						// The disassembler displays 8-bit immediates as unsigned.
+						vals = map[int32]string{0: "0x0", 1: "0x1", -1: "0xff", 0x7f: "0x7f", -0x80: "0x80", 0xff: "0xff"}
+					}
+
+					for val, imm := range vals {
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { x.op.SizeMemDispImm(text, sz, b, disp, val) }), sz, x.insn, m, imm)
+					}
+				}
+
+				for _, x := range []struct {
+					op   MI
+					insn x86asm.Op
+				}{
+					{ROLi, x86asm.ROL},
+					{RORi, x86asm.ROR},
+					{SHLi, x86asm.SHL},
+					{SHRi, x86asm.SHR},
+					{SARi, x86asm.SAR},
+				} {
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { x.op.SizeMemDispImm8(text, sz, b, disp, 7) }), sz, x.insn, m, "0x7")
+				}
+
+				for _, x := range []struct {
+					op   M
+					insn x86asm.Op
+				}{
+					{INC, x86asm.INC},
+					{DEC, x86asm.DEC},
+					{NEG, x86asm.NEG},
+					{ROL, x86asm.ROL},
+					{ROR, x86asm.ROR},
+					{SHL, x86asm.SHL},
+					{SHR, x86asm.SHR},
+					{SAR, x86asm.SAR},
+				} {
+					inst := encodeTestInst(t, func(text *Buf) { x.op.SizeMemDisp(text, sz, b, disp) })
+					if x.op>>8 == 0xd3 {
+						checkTestMemInst(t, inst, sz, x.insn, m, "CL")
+					} else {
+						checkTestMemInst(t, inst, sz, x.insn, m)
+					}
+				}
+
+				if sz == Word || sz == Quad {
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { PUSH.SizeMemDisp(text, sz, b, disp) }), sz, x86asm.PUSH, m)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { POP.SizeMemDisp(text, sz, b, disp) }), sz, x86asm.POP, m)
+				}
+			}
+		}
+	}
+}
+
+func TestMemImmErrors(t *testing.T) {
+	for _, fn := range []func(*Buf){
+		func(text *Buf) { ADDi.SizeMemDispImm(text, Octet, 0, 0, 1) },
+		func(text *Buf) { ADDi.SizeMemDispImm(text, Byte, 0, 0, 0x100) },
+		func(text *Buf) { ADDi.SizeMemDispImm(text, Byte, 0, 0, 0x1000) },
+		func(text *Buf) { SUBi.SizeMemDispImm(text, Byte, 0, 0, -0x81) },
+		func(text *Buf) { ADDi.SizeMemDispImm(text, Word, 0, 0, 0x10000) },
+		func(text *Buf) { CMPi.SizeMemDispImm(text, Word, 0, 0, -0x8001) },
+		func(text *Buf) { SHLi.SizeMemDispImm8(text, Size(3), 0, 0, 1) },
+		func(text *Buf) { INC.SizeMemDisp(text, Octet, 0, 0) },
+		func(text *Buf) { PUSH.SizeMemDisp(text, Byte, 0, 0) },
+		func(text *Buf) { PUSH.SizeMemDisp(text, Long, 0, 0) },
+		func(text *Buf) { POP.SizeMemDisp(text, Byte, 0, 0) },
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		fn(text)
+		if len(text.Errors) != 1 || len(text.Bytes()) != 0 {
+			t.Errorf("errors=%v bytes=%x", text.Errors, text.Bytes())
+		}
+	}
+}
+
+func TestSegmentPrefix(t *testing.T) {
+	for _, seg := range []struct {
+		prefix Segment
+		reg    x86asm.Reg
+	}{
+		{FS, x86asm.FS},
+		{GS, x86asm.GS},
+	} {
+		for _, x := range []struct {
+			fn   func(*Buf)
+			op   x86asm.Op
+			base x86asm.Reg
+		}{
+			{func(text *Buf) { MOV.SegRegMemDisp(text, seg.prefix, I64, 9, 3, 0x10) }, x86asm.MOV, x86asm.RBX},
+			{func(text *Buf) { MOV.SegRegMemIndexDisp(text, seg.prefix, I32, 9, 3, 10, Scale2, 0x10) }, x86asm.MOV, x86asm.RBX},
+			{func(text *Buf) { MOV.SegRegAbs(text, seg.prefix, I64, 0, 0x10) }, x86asm.MOV, 0},
+			{func(text *Buf) { MOV8i.SegMemDispImm(text, seg.prefix, I32, 11, 0x10, 5) }, x86asm.MOV, x86asm.R11},
+			{func(text *Buf) { MOV16i.SegMemDispImm(text, seg.prefix, I32, 3, 0x10, 5) }, x86asm.MOV, x86asm.RBX},
+			{func(text *Buf) { MOV16i.SegAbsImm(text, seg.prefix, I32, 0x10, 5) }, x86asm.MOV, 0},
+			{func(text *Buf) { MOV32i.SegMemDispImm(text, seg.prefix, I64, 11, -0x1000, 5) }, x86asm.MOV, x86asm.R11},
+			{func(text *Buf) { MOV32i.SegAbsImm(text, seg.prefix, I64, 0x10, 5) }, x86asm.MOV, 0},
+			{func(text *Buf) { MOVSSD.SegRegMemDisp(text, seg.prefix, F32, 9, 11, 0x10) }, x86asm.MOVSS, x86asm.R11},
+			{func(text *Buf) { MOVSSD.SegRegAbs(text, seg.prefix, F64, 1, 0x10) }, x86asm.MOVSD_XMM, 0},
+			{func(text *Buf) { ADDi.SegSizeMemDispImm(text, seg.prefix, Word, 14, -0x1000, 0x1234) }, x86asm.ADD, x86asm.R14},
+			{func(text *Buf) { ADDi.SegSizeAbsImm(text, seg.prefix, Quad, -0x1000, 1) }, x86asm.ADD, 0},
+			{func(text *Buf) { SHLi.SegSizeMemDispImm8(text, seg.prefix, Word, 11, 0x10, 3) }, x86asm.SHL, x86asm.R11},
+			{func(text *Buf) { SHLi.SegSizeAbsImm8(text, seg.prefix, Byte, 0x10, 3) }, x86asm.SHL, 0},
+			{func(text *Buf) { INC.SegSizeMemDisp(text, seg.prefix, Quad, 3, 0x10) }, x86asm.INC, x86asm.RBX},
+			{func(text *Buf) { DEC.SegSizeAbs(text, seg.prefix, Word, 0x10) }, x86asm.DEC, 0},
+			{func(text *Buf) { XADD.SegRegMemDisp(text, seg.prefix, true, Word, 9, 11, 0x10) }, x86asm.XADD, x86asm.R11},
+			{func(text *Buf) { XADD.SegRegAbs(text, seg.prefix, true, Quad, 1, 0x10) }, x86asm.XADD, 0},
+			{func(text *Buf) { CMPXCHG.SegRegMemIndexDisp(text, seg.prefix, true, Long, 9, 3, 10, Scale2, 0x10) }, x86asm.CMPXCHG, x86asm.RBX},
+			{func(text *Buf) { MOVZX16.SegRegMemDisp(text, seg.prefix, I64, 9, 3, 0x10) }, x86asm.MOVZX, x86asm.RBX},
+			{func(text *Buf) { MOVSX16.SegRegMemIndexDisp(text, seg.prefix, I32, 9, 11, 10, Scale1, 0x10) }, x86asm.MOVSX, x86asm.R11},
+			{func(text *Buf) { MOVZX8.SegRegAbs(text, seg.prefix, I32, 1, 0x10) }, x86asm.MOVZX, 0},
+			{func(text *Buf) { MOVSX8.SegRegMemIndexDisp(text, seg.prefix, I64, 9, 3, 10, Scale0, 0x10) }, x86asm.MOVSX, x86asm.RBX},
+			{func(text *Buf) { MOV8.SegRegMemDisp(text, seg.prefix, I32, 6, 11, 0x10) }, x86asm.MOV, x86asm.R11},
+			{func(text *Buf) { MOV8mr.SegRegMemIndexDisp(text, seg.prefix, I32, 6, 3, 10, Scale3, 0x10) }, x86asm.MOV, x86asm.RBX},
+			{func(text *Buf) { MOV8mr.SegRegAbs(text, seg.prefix, I32, 1, 0x10) }, x86asm.MOV, 0},
+			{func(text *Buf) { MOV16.SegRegMemDisp(text, seg.prefix, I32, 9, 3, 0x10) }, x86asm.MOV, x86asm.RBX},
+			{func(text *Buf) { MOV16mr.SegRegMemIndexDisp(text, seg.prefix, I32, 9, 11, 10, Scale2, 0x10) }, x86asm.MOV, x86asm.R11},
+			{func(text *Buf) { MOV16mr.SegRegAbs(text, seg.prefix, I32, 1, 0x10) }, x86asm.MOV, 0},
+			{func(text *Buf) { POPCNT.SegRegMemDisp(text, seg.prefix, I64, 9, 3, 0x10) }, x86asm.POPCNT, x86asm.RBX},
+			{func(text *Buf) { POPCNT.SegRegAbs(text, seg.prefix, I32, 1, 0x10) }, x86asm.POPCNT, 0},
+			{func(text *Buf) { MOVUPSD.SegRegMemDisp(text, seg.prefix, F64, 9, 11, 0x10) }, x86asm.MOVUPD, x86asm.R11},
+			{func(text *Buf) { MOVUPSD.SegRegAbs(text, seg.prefix, F32, 1, 0x10) }, x86asm.MOVUPS, 0},
+		} {
+			text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+			inst := encodeTestInstBuf(t, text, x.fn) // whole buffer is one instruction
+			checkTestInst(t, inst, x.op)
+
+			if code := text.Bytes(); code[0] != byte(seg.prefix) {
+				t.Errorf("%v: prefix not first: %x", x.op, code)
+			}
+			if text.Addr != int32(inst.Len) {
+				t.Errorf("%v: address %d, length %d", x.op, text.Addr, inst.Len)
+			}
+			if lock := x.op == x86asm.XADD || x.op == x86asm.CMPXCHG; testLocked(inst) != lock {
+				t.Errorf("%v: lock prefix: %v", x.op, inst.Prefix)
+			}
+
+			found := false
+			for _, arg := range inst.Args {
+				if m, ok := arg.(x86asm.Mem); ok {
+					found = true
+					if m.Segment != seg.reg {
+						t.Errorf("%v: segment %v", x.op, m.Segment)
+					}
+					if m.Base != x.base {
+						t.Errorf("%v: base %v", x.op, m.Base)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("%v: memory operand not found", x.op)
+			}
+		}
+	}
+
+	for _, x := range []struct {
+		fn   func(*Buf)
+		code string
+	}{
+		{func(text *Buf) { MOV.SegRegAbs(text, GS, I64, 0, 0x10) }, "65488b042510000000"},
+		{func(text *Buf) { MOV.SegRegMemDisp(text, NoSegment, I64, 0, 3, 0x10) }, "488b4310"},
+		{func(text *Buf) { MOV16i.SegMemDispImm(text, FS, I32, 3, 0x10, 5) }, "6466c743100500"},
+		{func(text *Buf) { MOVSSD.SegRegMemDisp(text, GS, F32, 9, 11, 0x10) }, "65f3450f104b10"},
+		{func(text *Buf) { ADDi.SegSizeAbsImm(text, FS, Quad, -0x1000, 1) }, "644883042500f0ffff01"},
+		{func(text *Buf) { XADD.SegRegMemDisp(text, GS, true, Quad, 1, 3, 0x10) }, "65f0480fc14b10"},
+		{func(text *Buf) { XADD.SegRegAbs(text, GS, true, Long, 0, 0x10) }, "65f00fc1042510000000"},
+		{func(text *Buf) { MOVUPSD.SegRegMemDisp(text, FS, F64, 9, 11, 0x10) }, "6466450f104b10"},
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		x.fn(text)
+		if code := hex.EncodeToString(text.Bytes()); code != x.code {
+			t.Errorf("%s: %s", x.code, code)
+		}
+	}
+
+	// The disassembler doesn't support the prefix with VEX-encoded instructions.
+	text := &Buf{Buffer: buffer.NewLimited(nil, 32), VEX: true}
+	MOVSSD.SegRegMemDisp(text, GS, F32, 9, 11, 0x10)
+	MOVSSD.SegRegAbs(text, FS, F64, 1, 0x10)
+	MOVUPSD.SegRegMemDisp(text, GS, F32, 9, 11, 0x10)
+	if code := hex.EncodeToString(text.Bytes()); code != "65c4417a104b10"+"64c5fb100c2510000000"+"65c44178104b10" {
+		t.Errorf("VEX: %s", code)
+	}
+}
+
+func TestNarrowInstructions(t *testing.T) {
+	for _, x := range []struct {
+		t    Type
+		regs *[16]string
+	}{
+		{I32, &testGPRegs32},
+		{I64, &testGPRegs64},
+	} {
+		for i := 0; i <= 15; i++ {
+			ri, si := Reg(i), x.regs[i]
+
+			for j := 0; j <= 15; j++ {
+				rj := Reg(j)
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVZX8.RegReg(text, x.t, ri, rj) }), x86asm.MOVZX, si, testGPRegs8[j])
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVSX8.RegReg(text, x.t, ri, rj) }), x86asm.MOVSX, si, testGPRegs8[j])
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVZX16.RegReg(text, x.t, ri, rj) }), x86asm.MOVZX, si, testGPRegs16[j])
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVSX16.RegReg(text, x.t, ri, rj) }), x86asm.MOVSX, si, testGPRegs16[j])
+			}
+
+			for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+				index := (base + i + 1) & 15
+				if index == 4 {
+					index = 12
+				}
+				b, ix := Reg(base), Reg(index)
+
+				for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+					m := "[" + testGPRegs64[base] + dispStr + "]"
+					mi := "[" + testGPRegs64[base] + "+2*" + testGPRegs64[index] + dispStr + "]"
+
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVZX8.RegMemDisp(text, x.t, ri, b, disp) }), Byte, x86asm.MOVZX, si, m)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVSX8.RegMemDisp(text, x.t, ri, b, disp) }), Byte, x86asm.MOVSX, si, m)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVZX16.RegMemDisp(text, x.t, ri, b, disp) }), Word, x86asm.MOVZX, si, m)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVSX16.RegMemDisp(text, x.t, ri, b, disp) }), Word, x86asm.MOVSX, si, m)
+
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVZX8.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Byte, x86asm.MOVZX, si, mi)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVSX8.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Byte, x86asm.MOVSX, si, mi)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVZX16.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Word, x86asm.MOVZX, si, mi)
+					checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVSX16.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Word, x86asm.MOVSX, si, mi)
+
+					if x.t == I32 {
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV8mr.RegMemDisp(text, x.t, ri, b, disp) }), Byte, x86asm.MOV, m, testGPRegs8[i])
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV16mr.RegMemDisp(text, x.t, ri, b, disp) }), Word, x86asm.MOV, m, testGPRegs16[i])
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV8.RegMemDisp(text, x.t, ri, b, disp) }), Byte, x86asm.MOV, testGPRegs8[i], m)
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV16.RegMemDisp(text, x.t, ri, b, disp) }), Word, x86asm.MOV, testGPRegs16[i], m)
+
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV8mr.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Byte, x86asm.MOV, mi, testGPRegs8[i])
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV16mr.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Word, x86asm.MOV, mi, testGPRegs16[i])
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV8.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Byte, x86asm.MOV, testGPRegs8[i], mi)
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOV16.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Word, x86asm.MOV, testGPRegs16[i], mi)
+					} else {
+						checkTestMemInst(t, encodeTestInst(t, func(text *Buf) { MOVSXD.RegMemIndexDisp(text, x.t, ri, b, ix, Scale1, disp) }), Long, x86asm.MOVSXD, si, mi)
+					}
+				}
+			}
+		}
+	}
+
+	// REX prefix is emitted only when needed.
+	for _, x := range []struct {
+		fn   func(*Buf)
+		code string
+	}{
+		{func(text *Buf) { MOV8mr.RegMemDisp(text, I32, 0, 3, 0) }, "8803"},
+		{func(text *Buf) { MOV8mr.RegMemDisp(text, I32, 6, 3, 0) }, "408833"},
+		{func(text *Buf) { MOV8mr.RegMemIndexDisp(text, I32, 7, 3, 1, Scale0, 0) }, "40883c0b"},
+		{func(text *Buf) { MOVZX8.RegReg(text, I32, 0, 3) }, "0fb6c3"},
+		{func(text *Buf) { MOVZX8.RegReg(text, I32, 0, 6) }, "400fb6c6"},
+		{func(text *Buf) { MOVSX8.RegReg(text, I64, 0, 4) }, "480fbec4"},
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		x.fn(text)
+		if code := hex.EncodeToString(text.Bytes()); code != x.code {
+			t.Errorf("Expected %s, found %s", x.code, code)
+		}
+	}
+}
@@ -14,6 +14,8 @@ const (
 	// GP opcodes
 	ADD     = RM(0x03)
 	OR      = RM(0x0b)
+	ADC     = RM(0x13)
+	SBB     = RM(0x1b)
 	AND     = RM(0x23)
 	SUB     = RM(0x2b)
 	XOR     = RM(0x33)
@@ -49,6 +51,8 @@ const (
 	JGcb    = Db(0x7f)
 	ADDi    = MI(0x81<<16 | 0x83<<8 | 0<<opcodeBase)
 	ORi     = MI(0x81<<16 | 0x83<<8 | 1<<opcodeBase)
+	ADCi    = MI(0x81<<16 | 0x83<<8 | 2<<opcodeBase)
+	SBBi    = MI(0x81<<16 | 0x83<<8 | 3<<opcodeBase)
 	ANDi    = MI(0x81<<16 | 0x83<<8 | 4<<opcodeBase)
 	SUBi    = MI(0x81<<16 | 0x83<<8 | 5<<opcodeBase)
 	XORi    = MI(0x81<<16 | 0x83<<8 | 6<<opcodeBase)
@@ -57,6 +61,8 @@ const (
 	MOV8mr  = RMdata8(0x88)
 	MOV16mr = RMdata16(0x89)
 	MOVmr   = RM(0x89) // RegReg is untested
+	MOV8    = RMdata8(0x8a)
+	MOV16   = RMdata16(0x8b)
 	MOV     = RM(0x8b)
 	LEA     = RM(0x8d)
 	POP     = M(0x8f<<8 | 0<<opcodeBase)
@@ -86,17 +92,30 @@ const (
 	SETLE   = Mex2(0x0f<<8 | 0x9e)
 	SETG    = Mex2(0x0f<<8 | 0x9f)
 	CDQ     = NP(0x99)
+	BT      = RM2(0x0f<<8 | 0xa3)  // MR opcode
+	SHLDi   = RMI2(0x0f<<8 | 0xa4) // MR opcode
+	SHLD    = RM2(0x0f<<8 | 0xa5)  // MR opcode; count in CL
+	BTS     = RM2(0x0f<<8 | 0xab)  // MR opcode
+	SHRDi   = RMI2(0x0f<<8 | 0xac) // MR opcode
+	SHRD    = RM2(0x0f<<8 | 0xad)  // MR opcode; count in CL
 	IMUL    = RM2(0x0f<<8 | 0xaf)
-	MOVZX8  = RM2(0x0f<<8 | 0xb6) // RegReg is untested
-	MOVZX16 = RM2(0x0f<<8 | 0xb7) // RegReg is untested
+	BTR     = RM2(0x0f<<8 | 0xb3) // MR opcode
+	MOVZX8  = RM2src8(0x0f<<8 | 0xb6)
+	MOVZX16 = RM2(0x0f<<8 | 0xb7)
 	MOV64i  = OI(0xb8)
 	POPCNT  = RMprefix(0xf3<<8 | 0xb8)
 	TZCNT   = RMprefix(0xf3<<8 | 0xbc)
 	LZCNT   = RMprefix(0xf3<<8 | 0xbd)
+	BTi     = MI2(0x0f<<16 | 0xba<<8 | 4<<opcodeBase)
+	BTSi    = MI2(0x0f<<16 | 0xba<<8 | 5<<opcodeBase)
+	BTRi    = MI2(0x0f<<16 | 0xba<<8 | 6<<opcodeBase)
+	BTCi    = MI2(0x0f<<16 | 0xba<<8 | 7<<opcodeBase)
+	BTC     = RM2(0x0f<<8 | 0xbb) // MR opcode
 	BSF     = RM2(0x0f<<8 | 0xbc)
 	BSR     = RM2(0x0f<<8 | 0xbd)
-	MOVSX8  = RM2(0x0f<<8 | 0xbe) // RegReg is untested
-	MOVSX16 = RM2(0x0f<<8 | 0xbf) // RegReg is untested
+	MOVSX8  = RM2src8(0x0f<<8 | 0xbe)
+	MOVSX16 = RM2(0x0f<<8 | 0xbf)
+	BSWAP   = O2(0x0f<<8 | 0xc8)
 	ROLi    = MI(0xc1<<8 | 0<<opcodeBase)
 	RORi    = MI(0xc1<<8 | 1<<opcodeBase)
 	SHLi    = MI(0xc1<<8 | 4<<opcodeBase)
@@ -118,12 +137,41 @@ const (
 	JMPcb   = Db(0xeb)
 	TEST8i  = MI8(0xf6<<8 | 0<<opcodeBase)
 	NEG     = M(0xf7<<8 | 3<<opcodeBase)
+	MUL     = M(0xf7<<8 | 4<<opcodeBase) // RDX:RAX = RAX * operand
+	IMUL1   = M(0xf7<<8 | 5<<opcodeBase) // one-operand form: RDX:RAX = RAX * operand
 	DIV     = M(0xf7<<8 | 6<<opcodeBase)
 	IDIV    = M(0xf7<<8 | 7<<opcodeBase)
 	INC     = M(0xff<<8 | 0<<opcodeBase)
 	DEC     = M(0xff<<8 | 1<<opcodeBase)
+	CALL    = M64(0xff<<8 | 2<<opcodeBase) // indirect
+	JMP     = M64(0xff<<8 | 4<<opcodeBase) // indirect
 	PUSH    = M(0xff<<8 | 6<<opcodeBase)
 
+	// atomic opcodes
+	XCHG       = RMlock(0x86)                    // MR opcode; implicitly locked with memory operand
+	CMPXCHG    = RMlock(0x0f<<8 | 0xb0)          // MR opcode; compares with and loads into AL/AX/EAX/RAX
+	XADD       = RMlock(0x0f<<8 | 0xc0)          // MR opcode
+	CMPXCHG16B = M2lock(0xc7<<8 | 1<<opcodeBase) // compares with RDX:RAX and stores RCX:RBX
+	LFENCE     = NP3(0x0f<<16 | 0xae<<8 | 0xe8)
+	MFENCE     = NP3(0x0f<<16 | 0xae<<8 | 0xf0)
+	SFENCE     = NP3(0x0f<<16 | 0xae<<8 | 0xf8)
+
+	// CET opcodes
+	ENDBR64 = NP4(0xf3<<24 | 0x0f<<16 | 0x1e<<8 | 0xfa) // indirect branch target marker
+
+	// string opcodes; source address in RSI, destination address in RDI,
+	// value in AL/EAX/RAX, REP count in RCX, direction according to DF
+	MOVSB    = NP(0xa4)       // [RDI] = [RSI]
+	MOVS     = NP(0xa5)       // MOVSD or MOVSQ
+	STOSB    = NP(0xaa)       // [RDI] = AL
+	STOS     = NP(0xab)       // STOSD or STOSQ
+	REPMOVSB = NPprefix(0xa4) // REP MOVSB
+	REPMOVS  = NPprefix(0xa5) // REP MOVSD or REP MOVSQ
+	REPSTOSB = NPprefix(0xaa) // REP STOSB
+	REPSTOS  = NPprefix(0xab) // REP STOSD or REP STOSQ
+	CLD      = NP(0xfc)       // clear DF: increment RSI and RDI
+	STD      = NP(0xfd)       // set DF: decrement RSI and RDI
+
 	// GP opcode pairs
 	JPc  = D12(JPcd)<<16 | D12(JPcb)
 	JLEc = D12(JLEcd)<<16 | D12(JLEcb)
@@ -163,7 +211,6 @@ const (
 	MINSSD    = RMscalar(0x5d)                              // MINSS or MINSD
 	DIVSSD    = RMscalar(0x5e)                              // DIVSS or DIVSD
 	MAXSSD    = RMscalar(0x5f)                              // MAXSS or MAXSD
-	PXOR      = RMprefix(0x66<<8 | 0xef)
 	PSRAi     = RMIpackedsz("\x00\x71\x72\x00\x00\x00\x04\x04\x00\x00") // W/L only
 	PSRLi     = RMIpackedsz("\x00\x71\x72\x73\x73\x00\x02\x02\x02\x03") // W/L/Q/O only
 	PSLLi     = RMIpackedsz("\x00\x71\x72\x73\x73\x00\x06\x06\x06\x07") // W/L/Q/O only
@@ -173,6 +220,71 @@ const (
 	PSUB      = RMpackedsz(0xfb<<24 | 0xfa<<16 | 0xf9<<8 | 0xf8)
 	PADD      = RMpackedsz(0xd4<<24 | 0xfe<<16 | 0xfd<<8 | 0xfc)
 
+	// packed multiply
+	PMULL   = RMpackedsz38("\x00\x00\xd5\x00\x38\x40\x00\x00") // PMULL{W/D}
+	PMULH   = RMpackedsz38("\x00\x00\xe5\x00")                 // PMULHW
+	PMULHU  = RMpackedsz38("\x00\x00\xe4\x00")                 // PMULHUW
+	PMULHRS = RMpackedsz38("\x00\x00\x38\x0b")                 // PMULHRSW
+	PMULUDQ = RMpackedsz38("\x00\x00\x00\x00\x00\x00\xf4\x00") // Q only (low unsigned L of each Q)
+	PMULDQ  = RMpackedsz38("\x00\x00\x00\x00\x00\x00\x38\x28") // Q only (low signed L of each Q)
+
+	// horizontal add, absolute value, sign, multiply-add, sum of absolute differences
+	PHADD     = RMpackedsz38("\x00\x00\x38\x01\x38\x02") // PHADD{W/D}
+	PABS      = RMpackedsz38("\x38\x1c\x38\x1d\x38\x1e") // PABS{B/W/D}
+	PSIGN     = RMpackedsz38("\x38\x08\x38\x09\x38\x0a") // PSIGN{B/W/D}
+	PMADDWD   = RMpackedsz(0xf5 << 8)                    // W only (signed W pairs to L)
+	PMADDUBSW = RMpackedsz38("\x38\x04")                 // B only (unsigned B times signed B pairs to W)
+	PSADBW    = RMpackedsz(0xf6)                         // B only (unsigned B octets to W)
+
+	// packed saturating arithmetic, rounding average
+	PADDS  = RMpackedsz(0xed<<8 | 0xec) // B/W only (signed)
+	PADDUS = RMpackedsz(0xdd<<8 | 0xdc) // B/W only (unsigned)
+	PSUBS  = RMpackedsz(0xe9<<8 | 0xe8) // B/W only (signed)
+	PSUBUS = RMpackedsz(0xd9<<8 | 0xd8) // B/W only (unsigned)
+	PAVG   = RMpackedsz(0xe3<<8 | 0xe0) // B/W only (unsigned)
+
+	// unpack (interleave), pack (narrow)
+	PUNPCKL = RMpackedsz(0x6c<<24 | 0x62<<16 | 0x61<<8 | 0x60) // PUNPCKL{BW/WD/DQ/QDQ}
+	PUNPCKH = RMpackedsz(0x6d<<24 | 0x6a<<16 | 0x69<<8 | 0x68) // PUNPCKH{BW/WD/DQ/QDQ}
+	PACKSS  = RMpackedsz38("\x00\x00\x63\x00\x6b\x00")         // PACKSS{WB/DW} (signed saturation)
+	PACKUS  = RMpackedsz38("\x00\x00\x67\x00\x38\x2b")         // PACKUS{WB/DW} (unsigned saturation)
+
+	// sign and zero extension
+	PMOVSX = PMovx(0x20) // PMOVSX{BW/BD/BQ/WD/WQ/DQ}
+	PMOVZX = PMovx(0x30) // PMOVZX{BW/BD/BQ/WD/WQ/DQ}
+
+	// packed conversions
+	CVTDQ2PS  = RMprefixnt(0x5b)           // L to F32
+	CVTTPS2DQ = RMprefixnt(0xf3<<8 | 0x5b) // F32 to L (truncate)
+	CVTPS2PD  = RMprefixnt(0x5a)           // low F32 pair to F64
+	CVTPD2PS  = RMprefixnt(0x66<<8 | 0x5a) // F64 to low F32 pair
+	CVTDQ2PD  = RMprefixnt(0xf3<<8 | 0xe6) // low L pair to F64
+	CVTTPD2DQ = RMprefixnt(0x66<<8 | 0xe6) // F64 to low L pair (truncate)
+
+	// mask extraction (general-purpose destination register)
+	PMOVMSKB  = RRprefixnt(0x66<<8 | 0xd7)
+	MOVMSKPSD = RRpacked(0x50) // MOVMSKPS or MOVMSKPD
+
+	// packed compare, logic
+	PCMPEQ = RMpackedsz38("\x74\x00\x75\x00\x76\x00\x38\x29") // PCMPEQ{B/W/D/Q}
+	PCMPGT = RMpackedsz38("\x64\x00\x65\x00\x66\x00\x38\x37") // PCMPGT{B/W/D/Q} (signed)
+	PAND   = RMprefixnt(0x66<<8 | 0xdb)
+	PANDN  = RMprefixnt(0x66<<8 | 0xdf) // first operand is inverted
+	POR    = RMprefixnt(0x66<<8 | 0xeb)
+	PXOR   = RMprefixnt(0x66<<8 | 0xef)
+	PTEST  = RMprefix38nt(0x66<<8 | 0x17) // sets ZF and CF
+
+	// packed floating-point arithmetic, compare, rounding
+	SQRTPSD  = RMpacked(0x51)  // SQRTPS or SQRTPD
+	ADDPSD   = RMpacked(0x58)  // ADDPS or ADDPD
+	MULPSD   = RMpacked(0x59)  // MULPS or MULPD
+	SUBPSD   = RMpacked(0x5c)  // SUBPS or SUBPD
+	MINPSD   = RMpacked(0x5d)  // MINPS or MINPD
+	DIVPSD   = RMpacked(0x5e)  // DIVPS or DIVPD
+	MAXPSD   = RMpacked(0x5f)  // MAXPS or MAXPD
+	CMPPSD   = CmpPacked(0xc2) // CMPPS or CMPPD
+	ROUNDPSD = RMIpacked(0x3a) // ROUNDPS or ROUNDPD
+
 	// shuffle, insert, extract, blend
 	PBLENDi = PBlendi(0x0d<<24| 0x0c<<16| 0x0e<<8 | 0) // W/L/Q only
 	PSHUFDi = PShufi("\x66\x0f\x70")
@@ -180,6 +292,71 @@ const (
 	PSHUFLWi = PShufi("\xf2\x0f\x70")
 	SHUFPDi = PShufi("\x66\x0f\xc6")
 	SHUFPSi = PShufi("\x0f\xc6")
+
+	PALIGNRi = PShufi("\x66\x0f\x3a\x0f")
+	PSHUFB   = RMprefix38nt(0x66<<8 | 0x00)
+
+	PBLENDV  = RMblendv(0x4c<<8 | 0x10) // PBLENDVB
+	BLENDVPS = RMblendv(0x4a<<8 | 0x14)
+	BLENDVPD = RMblendv(0x4b<<8 | 0x15)
+
+	PINSR     = RMIlane("\x3a\x20\xc4\x00\x3a\x22\x3a\x22") // PINSR{B/W/D/Q}
+	PEXTR     = RMIlane("\x3a\x14\x3a\x15\x3a\x16\x3a\x16") // PEXTR{B/W/D/Q}; register parameters reversed
+	INSERTPS  = RMIlane("\x00\x00\x00\x00\x3a\x21")         // L only
+	EXTRACTPS = RMIlane("\x00\x00\x00\x00\x3a\x17")         // L only; register parameters reversed
+
+	// AVX opcodes
+	VZEROUPPER = NPvex(uint16(vexL128)<<8 | 0x77) // before executing legacy SSE code
+	VZEROALL   = NPvex(uint16(vexL256)<<8 | 0x77)
+
+	// AVX-512 opcodes
+	VPSRAQ     = RMevex(1<<24 | 0x66<<16 | 0x00<<8 | 0xe2)            // shift count in xmm
+	VPSRAQi    = MIevex(1<<24 | 0x66<<16 | 0x72<<8 | 4<<opcodeBase)   // VPSRAQ with imm8
+	VPMULLQ    = RMevex(evexBcst | 1<<24 | 0x66<<16 | 0x38<<8 | 0x40) // AVX512DQ
+	VPERMB     = RMevex(0<<24 | 0x66<<16 | 0x38<<8 | 0x8d)            // AVX512_VBMI
+	VPTERNLOGD = RMIevex(evexBcst | 0<<24 | 0x66<<16 | 0x3a<<8 | 0x25)
+	VPTERNLOGQ = RMIevex(evexBcst | 1<<24 | 0x66<<16 | 0x3a<<8 | 0x25)
+	KMOV       = Kmov(0x92) // KMOV{B/W/D/Q} to opmask register
+	KMOVmr     = Kmov(0x93) // KMOV{B/W/D/Q} from opmask register
+
+	// FMA3 opcodes
+	VFMADD132PSD  = FMApacked(0x98) // VFMADD132PS or VFMADD132PD
+	VFMADD132SSD  = FMAscalar(0x99) // VFMADD132SS or VFMADD132SD
+	VFMSUB132PSD  = FMApacked(0x9a) // VFMSUB132PS or VFMSUB132PD
+	VFMSUB132SSD  = FMAscalar(0x9b) // VFMSUB132SS or VFMSUB132SD
+	VFNMADD132PSD = FMApacked(0x9c) // VFNMADD132PS or VFNMADD132PD
+	VFNMADD132SSD = FMAscalar(0x9d) // VFNMADD132SS or VFNMADD132SD
+	VFNMSUB132PSD = FMApacked(0x9e) // VFNMSUB132PS or VFNMSUB132PD
+	VFNMSUB132SSD = FMAscalar(0x9f) // VFNMSUB132SS or VFNMSUB132SD
+	VFMADD213PSD  = FMApacked(0xa8) // VFMADD213PS or VFMADD213PD
+	VFMADD213SSD  = FMAscalar(0xa9) // VFMADD213SS or VFMADD213SD
+	VFMSUB213PSD  = FMApacked(0xaa) // VFMSUB213PS or VFMSUB213PD
+	VFMSUB213SSD  = FMAscalar(0xab) // VFMSUB213SS or VFMSUB213SD
+	VFNMADD213PSD = FMApacked(0xac) // VFNMADD213PS or VFNMADD213PD
+	VFNMADD213SSD = FMAscalar(0xad) // VFNMADD213SS or VFNMADD213SD
+	VFNMSUB213PSD = FMApacked(0xae) // VFNMSUB213PS or VFNMSUB213PD
+	VFNMSUB213SSD = FMAscalar(0xaf) // VFNMSUB213SS or VFNMSUB213SD
+	VFMADD231PSD  = FMApacked(0xb8) // VFMADD231PS or VFMADD231PD
+	VFMADD231SSD  = FMAscalar(0xb9) // VFMADD231SS or VFMADD231SD
+	VFMSUB231PSD  = FMApacked(0xba) // VFMSUB231PS or VFMSUB231PD
+	VFMSUB231SSD  = FMAscalar(0xbb) // VFMSUB231SS or VFMSUB231SD
+	VFNMADD231PSD = FMApacked(0xbc) // VFNMADD231PS or VFNMADD231PD
+	VFNMADD231SSD = FMAscalar(0xbd) // VFNMADD231SS or VFNMADD231SD
+	VFNMSUB231PSD = FMApacked(0xbe) // VFNMSUB231PS or VFNMSUB231PD
+	VFNMSUB231SSD = FMAscalar(0xbf) // VFNMSUB231SS or VFNMSUB231SD
+
+	// BMI1 and BMI2 opcodes
+	ANDN   = RVMvex(vexBMI1 | 0x00<<16 | 0x38<<8 | 0xf2)
+	BLSR   = VMvex(vexBMI1 | 0x38<<16 | 0xf3<<8 | 1<<opcodeBase)
+	BLSMSK = VMvex(vexBMI1 | 0x38<<16 | 0xf3<<8 | 2<<opcodeBase)
+	BLSI   = VMvex(vexBMI1 | 0x38<<16 | 0xf3<<8 | 3<<opcodeBase)
+	BZHI   = RMVvex(vexBMI2 | 0x00<<16 | 0x38<<8 | 0xf5) // index in third register
+	PDEP   = RVMvex(vexBMI2 | 0xf2<<16 | 0x38<<8 | 0xf5)
+	PEXT   = RVMvex(vexBMI2 | 0xf3<<16 | 0x38<<8 | 0xf5)
+	SHLX   = RMVvex(vexBMI2 | 0x66<<16 | 0x38<<8 | 0xf7) // count in third register
+	SHRX   = RMVvex(vexBMI2 | 0xf2<<16 | 0x38<<8 | 0xf7) // count in third register
+	SARX   = RMVvex(vexBMI2 | 0xf3<<16 | 0x38<<8 | 0xf7) // count in third register
+	RORX   = RMIvex(vexBMI2 | 0xf2<<16 | 0x3a<<8 | 0xf0)
 )
 
 // Arithmetic logic instructions
@@ -27,3 +27,7 @@ func typeRexW(t Type) rexWRXB { return rexWRXB(t & 8) } // RexW == 8
 func regRexR(r Reg) rexWRXB { return rexWRXB(r>>3) << 2 } // 8..15 => 4
 func regRexX(r Reg) rexWRXB { return rexWRXB(r>>3) << 1 } // 8..15 => 2
 func regRexB(r Reg) rexWRXB { return rexWRXB(r>>3) << 0 } // 8..15 => 1
+
+// regRexByte reports if a REX prefix is needed to access the low byte of a
+// register: SPL, BPL, SIL and DIL are otherwise encoded as AH, CH, DH and BH.
+func regRexByte(r Reg) bool { return r&^3 == 4 }
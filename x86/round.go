@@ -5,9 +5,12 @@
 package in
 
 const (
-	// Immediate values for ROUNDSSD
+	// Immediate values for ROUNDSSD and ROUNDPSD
 	RoundModeNearest = 0x0
 	RoundModeFloor   = 0x1
 	RoundModeCeil    = 0x2
 	RoundModeTrunc   = 0x3
+
+	// Can be combined with a RoundMode value
+	RoundSuppressPrecision = 0x8 // don't signal precision exception
 )
@@ -0,0 +1,14 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+// Segment override prefix for memory operands
+type Segment byte
+
+const (
+	NoSegment = Segment(0)
+	FS        = Segment(0x64)
+	GS        = Segment(0x65)
+)
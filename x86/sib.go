@@ -15,6 +15,7 @@ const (
 	Scale3 = Scale(3 << 6)
 
 	noIndex = Index(4 << 3)
+	noBase  = Base(5) // with ModMem
 )
 
 func TypeScale(t Type) Scale { return Scale(t.Size()>>3|2) << 6 } // Scale2 or Scale3
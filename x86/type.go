@@ -45,6 +45,15 @@ const (
 	Octet = Size(16)
 )
 
+// Register width for vector operations
+type Width uint8
+
+const (
+	Width128 = Width(16) // XMM
+	Width256 = Width(32) // YMM
+	Width512 = Width(64) // ZMM
+)
+
 // Category of a non-void type.
 func (t Type) Category() ScalarCategory {
 	return ScalarCategory(t & 1)
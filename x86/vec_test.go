@@ -4,130 +4,471 @@ import (
 	"fmt"
 	"github.com/tsavola/wag/buffer"
 	"golang.org/x/arch/x86/x86asm"
+	"strings"
 	"testing"
 )
 
-func TestVectorInstructions(t *testing.T) {
-	testEncode := func(fn func(*Buf)) x86asm.Inst {
-		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
-		fn(text)
-		if len(text.Errors) != 0 {
-			for err := range text.Errors {
-				t.Error(err)
-			}
-		}
-		insn, err := x86asm.Decode(text.Bytes(), 64)
-		if err != nil {
-			t.Error(err)
-		}
-		return insn
+func encodeTestInst(t *testing.T, fn func(*Buf)) x86asm.Inst {
+	t.Helper()
+	return encodeTestInstBuf(t, &Buf{Buffer: buffer.NewLimited(nil, 32)}, fn)
+}
+
+func encodeTestInstVEX(t *testing.T, fn func(*Buf)) x86asm.Inst {
+	t.Helper()
+	return encodeTestInstBuf(t, &Buf{Buffer: buffer.NewLimited(nil, 32), VEX: true}, fn)
+}
+
+// encodeTestInstBuf checks that the buffer contains exactly one instruction.
+func encodeTestInstBuf(t *testing.T, text *Buf, fn func(*Buf)) x86asm.Inst {
+	t.Helper()
+	fn(text)
+	for _, err := range text.Errors {
+		t.Error(err)
+	}
+	insn, err := x86asm.Decode(text.Bytes(), 64)
+	if err != nil {
+		t.Error(err)
+	} else if insn.Len != len(text.Bytes()) {
+		t.Errorf("Decoded %v of %v bytes", insn.Len, len(text.Bytes()))
 	}
+	return insn
+}
 
-	checkInst := func(inst x86asm.Inst, op x86asm.Op, args ...string) {
-		if inst.Op != op {
-			t.Errorf("Found op=%s", inst.Op.String())
-		}
-		if len(inst.Args) < len(args) {
-			t.Errorf("Expected to find %v args, found %v", len(args), len(inst.Args))
-		}
-		for i, expected := range args {
-			found := inst.Args[i]
-			if found == nil {
-				t.Errorf("Found nil arg at i=%v", i)
-			} else if found.String() != expected {
-				t.Errorf("Expected to find arg %s at i=%v, found %s", expected, i, found.String())
-			}
+func checkTestInst(t *testing.T, inst x86asm.Inst, op x86asm.Op, args ...string) {
+	t.Helper()
+	if inst.Op != op {
+		t.Errorf("Found op=%s", inst.Op.String())
+	}
+	if len(inst.Args) < len(args) {
+		t.Errorf("Expected to find %v args, found %v", len(args), len(inst.Args))
+	}
+	for i, expected := range args {
+		found := inst.Args[i]
+		if found == nil {
+			t.Errorf("Found nil arg at i=%v", i)
+		} else if found.String() != expected {
+			t.Errorf("Expected to find arg %s at i=%v, found %s", expected, i, found.String())
 		}
 	}
+}
 
+func checkTestMemInst(t *testing.T, inst x86asm.Inst, sz Size, op x86asm.Op, args ...string) {
+	t.Helper()
+	checkTestInst(t, inst, op, args...)
+	if inst.MemBytes != int(sz) {
+		t.Errorf("%v: MemBytes=%v", op, inst.MemBytes)
+	}
+}
+
+func TestVectorInstructions(t *testing.T) {
 	for i := 0; i <= 15; i++ {
 		for j := 0; j <= 15; j++ {
 			xi := fmt.Sprintf("X%d", i)
 			xj := fmt.Sprintf("X%d", j)
 
 			// Octet moves:
-			checkInst(testEncode(func(text *Buf) { MOVOA.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQA, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVOU.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQU, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVOAmr.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQA, xj, xi)
-			checkInst(testEncode(func(text *Buf) { MOVOUmr.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQU, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOA.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQA, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOU.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQU, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOAmr.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQA, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOUmr.RegReg(text, Reg(i), Reg(j)) }), x86asm.MOVDQU, xj, xi)
 
 			// Packed shifts with imm8:
-			checkInst(testEncode(func(text *Buf) { PSRLi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSRLW, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSRLi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSRLD, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSRLi.RegImm8(text, Quad, Reg(i), 0x4) }), x86asm.PSRLQ, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSRLi.RegImm8(text, Octet, Reg(i), 0x4) }), x86asm.PSRLDQ, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSLLi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSLLW, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSLLi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSLLD, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSLLi.RegImm8(text, Quad, Reg(i), 0x4) }), x86asm.PSLLQ, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSLLi.RegImm8(text, Octet, Reg(i), 0x4) }), x86asm.PSLLDQ, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSRAi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSRAW, xi, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSRAi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSRAD, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRLi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSRLW, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRLi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSRLD, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRLi.RegImm8(text, Quad, Reg(i), 0x4) }), x86asm.PSRLQ, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRLi.RegImm8(text, Octet, Reg(i), 0x4) }), x86asm.PSRLDQ, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLLi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSLLW, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLLi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSLLD, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLLi.RegImm8(text, Quad, Reg(i), 0x4) }), x86asm.PSLLQ, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLLi.RegImm8(text, Octet, Reg(i), 0x4) }), x86asm.PSLLDQ, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRAi.RegImm8(text, Word, Reg(i), 0x4) }), x86asm.PSRAW, xi, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRAi.RegImm8(text, Long, Reg(i), 0x4) }), x86asm.PSRAD, xi, "0x4")
 
 			// Packed shifts:
-			checkInst(testEncode(func(text *Buf) { PSRL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSRLW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSRL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSRLD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSRL.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSRLQ, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSLL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSLLW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSLL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSLLD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSLL.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSLLQ, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSRA.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSRAW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSRA.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSRAD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSRLW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSRLD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRL.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSRLQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSLLW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSLLD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLL.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSLLQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRA.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSRAW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRA.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSRAD, xi, xj)
 
 			// Packed add/subtract/and-not:
-			checkInst(testEncode(func(text *Buf) { PADD.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PADDB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PADD.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PADDW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PADD.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PADDD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PADD.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PADDQ, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSUB.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSUBB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSUB.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSUBW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSUB.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSUBD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PSUB.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSUBQ, xi, xj)
-			checkInst(testEncode(func(text *Buf) { ANDNPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.ANDNPS, xi, xj)
-			checkInst(testEncode(func(text *Buf) { ANDNPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.ANDNPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PADDB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PADDW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PADDD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PADDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSUBB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSUBW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSUBD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PSUBQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDNPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.ANDNPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDNPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.ANDNPD, xi, xj)
 
 			// Aligned/unaligned moves:
-			checkInst(testEncode(func(text *Buf) { MOVAPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVAPS, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVAPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVAPD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVUPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVUPS, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVUPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVUPD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { MOVAPSDmr.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVAPS, xj, xi)
-			checkInst(testEncode(func(text *Buf) { MOVAPSDmr.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVAPD, xj, xi)
-			checkInst(testEncode(func(text *Buf) { MOVUPSDmr.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVUPS, xj, xi)
-			checkInst(testEncode(func(text *Buf) { MOVUPSDmr.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVUPD, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVAPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVAPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVAPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVAPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVUPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVUPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVAPSDmr.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVAPS, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVAPSDmr.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVAPD, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSDmr.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVUPS, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSDmr.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVUPD, xj, xi)
 
 			// Packed signed/unsigned min/max:
-			checkInst(testEncode(func(text *Buf) { PMINS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMINSB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMINS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMINSW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMINS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMINSD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMINU.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMINUB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMINU.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMINUW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMINU.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMINUD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMAXSB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMAXSW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMAXSD, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXU.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMAXUB, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXU.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMAXUW, xi, xj)
-			checkInst(testEncode(func(text *Buf) { PMAXU.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMAXUD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMINSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMINSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMINSD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINU.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMINUB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINU.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMINUW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINU.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMINUD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMAXSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMAXSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMAXSD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXU.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMAXUB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXU.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMAXUW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXU.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMAXUD, xi, xj)
+
+			// Packed multiply:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMULLW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PMULLD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULH.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMULHW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULHU.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMULHUW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULHRS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMULHRSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULUDQ.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PMULUDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULDQ.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PMULDQ, xi, xj)
+
+			// Horizontal add, absolute value, sign, multiply-add, sum of absolute differences:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PHADD.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PHADDW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PHADD.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PHADDD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PABS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PABSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PABS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PABSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PABS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PABSD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSIGN.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSIGNB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSIGN.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSIGNW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSIGN.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PSIGND, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDWD.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PMADDWD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDUBSW.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PMADDUBSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSADBW.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSADBW, xi, xj)
+
+			// Packed saturating arithmetic, rounding average:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PADDSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PADDSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDUS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PADDUSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDUS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PADDUSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSUBSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSUBSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBUS.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PSUBUSB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBUS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PSUBUSW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAVG.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PAVGB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAVG.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PAVGW, xi, xj)
+
+			// Packed conversions:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTDQ2PS.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTDQ2PS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTTPS2DQ.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTTPS2DQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTPS2PD.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTPS2PD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTPD2PS.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTPD2PS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTDQ2PD.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTDQ2PD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTTPD2DQ.RegReg(text, Reg(i), Reg(j)) }), x86asm.CVTTPD2DQ, xi, xj)
+
+			// Packed compare, logic:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PCMPEQB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PCMPEQW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PCMPEQD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PCMPEQQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PCMPGTB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PCMPGTW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PCMPGTD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PCMPGTQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAND.RegReg(text, Reg(i), Reg(j)) }), x86asm.PAND, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PANDN.RegReg(text, Reg(i), Reg(j)) }), x86asm.PANDN, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { POR.RegReg(text, Reg(i), Reg(j)) }), x86asm.POR, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PXOR.RegReg(text, Reg(i), Reg(j)) }), x86asm.PXOR, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PTEST.RegReg(text, Reg(i), Reg(j)) }), x86asm.PTEST, xi, xj)
+
+			// Packed floating-point arithmetic, compare:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SQRTPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.SQRTPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SQRTPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.SQRTPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADDPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.ADDPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADDPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.ADDPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MULPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MULPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SUBPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.SUBPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MINPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MINPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { DIVPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.DIVPD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MAXPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MAXPS, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegRegImm8(text, F32, Reg(i), Reg(j), CmpPredicateLT) }),
+				x86asm.CMPPS, xi, xj, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegRegImm8(text, F64, Reg(i), Reg(j), CmpPredicateORD) }),
+				x86asm.CMPPD, xi, xj, "0x7")
+
+			// Shuffle, align, unpack, pack:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFB.RegReg(text, Reg(i), Reg(j)) }), x86asm.PSHUFB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PALIGNRi.RegRegImm8(text, Reg(i), Reg(j), 8) }), x86asm.PALIGNR, xi, xj, "0x8")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PUNPCKLBW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PUNPCKLWD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PUNPCKLDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PUNPCKLQDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegReg(text, Byte, Reg(i), Reg(j)) }), x86asm.PUNPCKHBW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PUNPCKHWD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PUNPCKHDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegReg(text, Quad, Reg(i), Reg(j)) }), x86asm.PUNPCKHQDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKSS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PACKSSWB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKSS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PACKSSDW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKUS.RegReg(text, Word, Reg(i), Reg(j)) }), x86asm.PACKUSWB, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKUS.RegReg(text, Long, Reg(i), Reg(j)) }), x86asm.PACKUSDW, xi, xj)
+
+			// Sign and zero extension:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Byte, Word, Reg(i), Reg(j)) }), x86asm.PMOVSXBW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Byte, Long, Reg(i), Reg(j)) }), x86asm.PMOVSXBD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Byte, Quad, Reg(i), Reg(j)) }), x86asm.PMOVSXBQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Word, Long, Reg(i), Reg(j)) }), x86asm.PMOVSXWD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Word, Quad, Reg(i), Reg(j)) }), x86asm.PMOVSXWQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegReg(text, Long, Quad, Reg(i), Reg(j)) }), x86asm.PMOVSXDQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Byte, Word, Reg(i), Reg(j)) }), x86asm.PMOVZXBW, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Byte, Long, Reg(i), Reg(j)) }), x86asm.PMOVZXBD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Byte, Quad, Reg(i), Reg(j)) }), x86asm.PMOVZXBQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Word, Long, Reg(i), Reg(j)) }), x86asm.PMOVZXWD, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Word, Quad, Reg(i), Reg(j)) }), x86asm.PMOVZXWQ, xi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegReg(text, Long, Quad, Reg(i), Reg(j)) }), x86asm.PMOVZXDQ, xi, xj)
+
+			// Lane insert, extract:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegImm8(text, Byte, Reg(i), Reg(j), 15) }),
+				x86asm.PINSRB, xi, testGPRegs32[j], "0xf")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegImm8(text, Word, Reg(i), Reg(j), 7) }),
+				x86asm.PINSRW, xi, testGPRegs32[j], "0x7")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegImm8(text, Long, Reg(i), Reg(j), 3) }),
+				x86asm.PINSRD, xi, testGPRegs32[j], "0x3")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegImm8(text, Quad, Reg(i), Reg(j), 1) }),
+				x86asm.PINSRQ, xi, testGPRegs64[j], "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegRegImm8(text, Byte, Reg(i), Reg(j), 15) }),
+				x86asm.PEXTRB, testGPRegs32[j], xi, "0xf")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegRegImm8(text, Word, Reg(i), Reg(j), 7) }),
+				x86asm.PEXTRW, testGPRegs32[j], xi, "0x7")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegRegImm8(text, Long, Reg(i), Reg(j), 3) }),
+				x86asm.PEXTRD, testGPRegs32[j], xi, "0x3")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegRegImm8(text, Quad, Reg(i), Reg(j), 1) }),
+				x86asm.PEXTRQ, testGPRegs64[j], xi, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { INSERTPS.RegRegImm8(text, Long, Reg(i), Reg(j), 0x30) }),
+				x86asm.INSERTPS, xi, xj, "0x30")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { EXTRACTPS.RegRegImm8(text, Long, Reg(i), Reg(j), 2) }),
+				x86asm.EXTRACTPS, testGPRegs32[j], xi, "0x2")
+
+			// Mask extraction:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVMSKB.RegReg(text, Reg(i), Reg(j)) }), x86asm.PMOVMSKB, testGPRegs32[i], xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVMSKPSD.RegReg(text, F32, Reg(i), Reg(j)) }), x86asm.MOVMSKPS, testGPRegs32[i], xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVMSKPSD.RegReg(text, F64, Reg(i), Reg(j)) }), x86asm.MOVMSKPD, testGPRegs32[i], xj)
+
+			// Variable blend:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDV.RegRegMask(text, Reg(i), Reg(j), 0) }), x86asm.PBLENDVB, xi, xj, "X0")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPS.RegRegMask(text, Reg(i), Reg(j), 0) }), x86asm.BLENDVPS, xi, xj, "X0")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPD.RegRegMask(text, Reg(i), Reg(j), 0) }), x86asm.BLENDVPD, xi, xj, "X0")
+
+			// Rounding:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegRegImm8(text, F32, Reg(i), Reg(j), RoundModeFloor) }),
+				x86asm.ROUNDSS, xi, xj, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegRegImm8(text, F64, Reg(i), Reg(j), RoundModeCeil) }),
+				x86asm.ROUNDSD, xi, xj, "0x2")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDPSD.RegRegImm8(text, F32, Reg(i), Reg(j), RoundModeTrunc) }),
+				x86asm.ROUNDPS, xi, xj, "0x3")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) {
+				ROUNDPSD.RegRegImm8(text, F64, Reg(i), Reg(j), RoundModeNearest|RoundSuppressPrecision)
+			}), x86asm.ROUNDPD, xi, xj, "0x8")
 
 			// Packed blend:
-			checkInst(testEncode(func(text *Buf) { PBLENDi.RegRegImm8(text, Word, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegImm8(text, Word, Reg(i), Reg(j), 0x04) }),
 				x86asm.PBLENDW, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { PBLENDi.RegRegImm8(text, Long, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegImm8(text, Long, Reg(i), Reg(j), 0x04) }),
 				x86asm.BLENDPS, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { PBLENDi.RegRegImm8(text, Quad, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegImm8(text, Quad, Reg(i), Reg(j), 0x04) }),
 				x86asm.BLENDPD, xi, xj, "0x4")
 
 			// Packed shuffle:
-			checkInst(testEncode(func(text *Buf) { PSHUFDi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFDi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
 				x86asm.PSHUFD, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSHUFHWi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFHWi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
 				x86asm.PSHUFHW, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { PSHUFLWi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFLWi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
 				x86asm.PSHUFLW, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { SHUFPDi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPDi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
 				x86asm.SHUFPD, xi, xj, "0x4")
-			checkInst(testEncode(func(text *Buf) { SHUFPSi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPSi.RegRegImm8(text, Reg(i), Reg(j), 0x04) }),
 				x86asm.SHUFPS, xi, xj, "0x4")
 		}
 	}
 }
+
+func TestVectorMemInstructions(t *testing.T) {
+	for i := 0; i <= 15; i++ {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			xi := fmt.Sprintf("X%d", i)
+			r, b := Reg(i), Reg(base)
+
+			// Legacy-encoded displacement is printed as unsigned.
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+
+				// Packed multiply:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULL.RegMemDisp(text, Word, r, b, disp) }), x86asm.PMULLW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULL.RegMemDisp(text, Long, r, b, disp) }), x86asm.PMULLD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULH.RegMemDisp(text, Word, r, b, disp) }), x86asm.PMULHW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULHU.RegMemDisp(text, Word, r, b, disp) }), x86asm.PMULHUW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULHRS.RegMemDisp(text, Word, r, b, disp) }), x86asm.PMULHRSW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULUDQ.RegMemDisp(text, Quad, r, b, disp) }), x86asm.PMULUDQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMULDQ.RegMemDisp(text, Quad, r, b, disp) }), x86asm.PMULDQ, xi, m)
+
+				// Horizontal add, absolute value, sign, multiply-add, sum of absolute differences:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PHADD.RegMemDisp(text, Long, r, b, disp) }), x86asm.PHADDD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PABS.RegMemDisp(text, Word, r, b, disp) }), x86asm.PABSW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSIGN.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PSIGNB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDWD.RegMemDisp(text, Word, r, b, disp) }), x86asm.PMADDWD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDUBSW.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PMADDUBSW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSADBW.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PSADBW, xi, m)
+
+				// Packed saturating arithmetic, rounding average:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDS.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PADDSB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADDUS.RegMemDisp(text, Word, r, b, disp) }), x86asm.PADDUSW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBS.RegMemDisp(text, Word, r, b, disp) }), x86asm.PSUBSW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUBUS.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PSUBUSB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAVG.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PAVGB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAVG.RegMemDisp(text, Word, r, b, disp) }), x86asm.PAVGW, xi, m)
+
+				// Shuffle, align, unpack, pack:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFB.RegMemDisp(text, r, b, disp) }), x86asm.PSHUFB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PALIGNRi.RegMemDispImm8(text, r, b, disp, 8) }), x86asm.PALIGNR, xi, m, "0x8")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegMemDisp(text, Word, r, b, disp) }), x86asm.PUNPCKLWD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegMemDisp(text, Quad, r, b, disp) }), x86asm.PUNPCKHQDQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKSS.RegMemDisp(text, Long, r, b, disp) }), x86asm.PACKSSDW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKUS.RegMemDisp(text, Long, r, b, disp) }), x86asm.PACKUSDW, xi, m)
+
+				// Rounding:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegMemDispImm8(text, F32, r, b, disp, RoundModeTrunc) }),
+					x86asm.ROUNDSS, xi, m, "0x3")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegMemDispImm8(text, F64, r, b, disp, RoundModeFloor) }),
+					x86asm.ROUNDSD, xi, m, "0x1")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDPSD.RegMemDispImm8(text, F32, r, b, disp, RoundModeCeil) }),
+					x86asm.ROUNDPS, xi, m, "0x2")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDPSD.RegMemDispImm8(text, F64, r, b, disp, RoundModeNearest) }),
+					x86asm.ROUNDPD, xi, m, "0x0")
+
+				// Variable blend:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDV.RegMemDispMask(text, r, b, disp, 0) }), x86asm.PBLENDVB, xi, m, "X0")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPD.RegMemDispMask(text, r, b, disp, 0) }), x86asm.BLENDVPD, xi, m, "X0")
+
+				// Sign and zero extension:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegMemDisp(text, Byte, Word, r, b, disp) }), x86asm.PMOVSXBW, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegMemDisp(text, Long, Quad, r, b, disp) }), x86asm.PMOVSXDQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegMemDisp(text, Byte, Quad, r, b, disp) }), x86asm.PMOVZXBQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegMemDisp(text, Word, Long, r, b, disp) }), x86asm.PMOVZXWD, xi, m)
+
+				// Lane insert, extract:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegMemDispImm8(text, Byte, r, b, disp, 15) }), x86asm.PINSRB, xi, m, "0xf")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegMemDispImm8(text, Word, r, b, disp, 7) }), x86asm.PINSRW, xi, m, "0x7")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegMemDispImm8(text, Long, r, b, disp, 3) }), x86asm.PINSRD, xi, m, "0x3")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegMemDispImm8(text, Quad, r, b, disp, 1) }), x86asm.PINSRQ, xi, m, "0x1")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegMemDispImm8(text, Byte, r, b, disp, 15) }), x86asm.PEXTRB, m, xi, "0xf")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegMemDispImm8(text, Word, r, b, disp, 7) }), x86asm.PEXTRW, m, xi, "0x7")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegMemDispImm8(text, Long, r, b, disp, 3) }), x86asm.PEXTRD, m, xi, "0x3")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PEXTR.RegMemDispImm8(text, Quad, r, b, disp, 1) }), x86asm.PEXTRQ, m, xi, "0x1")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { INSERTPS.RegMemDispImm8(text, Long, r, b, disp, 0x30) }),
+					x86asm.INSERTPS, xi, m, "0x30")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { EXTRACTPS.RegMemDispImm8(text, Long, r, b, disp, 2) }),
+					x86asm.EXTRACTPS, m, xi, "0x2")
+
+				// Packed conversions:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTDQ2PS.RegMemDisp(text, r, b, disp) }), x86asm.CVTDQ2PS, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTTPS2DQ.RegMemDisp(text, r, b, disp) }), x86asm.CVTTPS2DQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTPS2PD.RegMemDisp(text, r, b, disp) }), x86asm.CVTPS2PD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTPD2PS.RegMemDisp(text, r, b, disp) }), x86asm.CVTPD2PS, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTDQ2PD.RegMemDisp(text, r, b, disp) }), x86asm.CVTDQ2PD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTTPD2DQ.RegMemDisp(text, r, b, disp) }), x86asm.CVTTPD2DQ, xi, m)
+
+				// Packed compare, logic:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegMemDisp(text, Byte, r, b, disp) }), x86asm.PCMPEQB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegMemDisp(text, Quad, r, b, disp) }), x86asm.PCMPEQQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegMemDisp(text, Long, r, b, disp) }), x86asm.PCMPGTD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegMemDisp(text, Quad, r, b, disp) }), x86asm.PCMPGTQ, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAND.RegMemDisp(text, r, b, disp) }), x86asm.PAND, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PANDN.RegMemDisp(text, r, b, disp) }), x86asm.PANDN, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { POR.RegMemDisp(text, r, b, disp) }), x86asm.POR, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PXOR.RegMemDisp(text, r, b, disp) }), x86asm.PXOR, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PTEST.RegMemDisp(text, r, b, disp) }), x86asm.PTEST, xi, m)
+
+				// Packed floating-point arithmetic, compare:
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SQRTPSD.RegMemDisp(text, F64, r, b, disp) }), x86asm.SQRTPD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADDPSD.RegMemDisp(text, F32, r, b, disp) }), x86asm.ADDPS, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MULPSD.RegMemDisp(text, F64, r, b, disp) }), x86asm.MULPD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SUBPSD.RegMemDisp(text, F32, r, b, disp) }), x86asm.SUBPS, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MINPSD.RegMemDisp(text, F64, r, b, disp) }), x86asm.MINPD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { DIVPSD.RegMemDisp(text, F32, r, b, disp) }), x86asm.DIVPS, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MAXPSD.RegMemDisp(text, F64, r, b, disp) }), x86asm.MAXPD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegMemDispImm8(text, F32, r, b, disp, CmpPredicateNLE) }),
+					x86asm.CMPPS, xi, m, "0x6")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegMemDispImm8(text, F64, r, b, disp, CmpPredicateEQ) }),
+					x86asm.CMPPD, xi, m, "0x0")
+			}
+		}
+	}
+
+	for i := 0; i <= 15; i++ {
+		for _, base := range []int{0, 1, 2, 3, 4, 6, 7, 8, 9, 10, 11, 12, 14, 15} {
+			for _, index := range []int{0, 1, 2, 3, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15} {
+				xi := fmt.Sprintf("X%d", i)
+				r, b, x := Reg(i), Reg(base), Reg(index)
+
+				for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "+0xfffff000"} {
+					m := "[" + testGPRegs64[base] + "+8*" + testGPRegs64[index] + dispStr + "]"
+
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVSX.RegMemIndexDisp(text, Byte, Word, r, b, x, Scale3, disp) }),
+						x86asm.PMOVSXBW, xi, m)
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.RegMemIndexDisp(text, Long, Quad, r, b, x, Scale3, disp) }),
+						x86asm.PMOVZXDQ, xi, m)
+				}
+			}
+		}
+	}
+}
+
+func TestVectorErrors(t *testing.T) {
+	for _, fn := range []func(*Buf){
+		func(text *Buf) { PMULL.RegReg(text, Byte, 0, 1) },
+		func(text *Buf) { PMULL.RegMemDisp(text, Quad, 0, 1, 0) },
+		func(text *Buf) { PMULH.RegReg(text, Long, 0, 1) },
+		func(text *Buf) { PMULUDQ.RegReg(text, Long, 0, 1) },
+		func(text *Buf) { PMULDQ.RegReg(text, Octet, 0, 1) },
+		func(text *Buf) { PBLENDV.RegRegMask(text, 0, 1, 2) },
+		func(text *Buf) { BLENDVPS.RegMemDispMask(text, 0, 1, 0, 15) },
+		func(text *Buf) { BLENDVPD.RegRegMask(text, 0, 1, 8) },
+		func(text *Buf) { PMOVSX.RegReg(text, Word, Byte, 0, 1) },
+		func(text *Buf) { PMOVZX.RegMemDisp(text, Quad, Octet, 0, 1, 0) },
+		func(text *Buf) { PMOVZX.RegMemIndexDisp(text, Long, Long, 0, 1, 2, Scale0, 0) },
+		func(text *Buf) { PACKSS.RegReg(text, Byte, 0, 1) },
+		func(text *Buf) { PACKUS.RegMemDisp(text, Quad, 0, 1, 0) },
+		func(text *Buf) { PUNPCKL.RegReg(text, Octet, 0, 1) },
+		func(text *Buf) { PINSR.RegRegImm8(text, Octet, 0, 1, 0) },
+		func(text *Buf) { PEXTR.RegMemDispImm8(text, Octet, 0, 1, 0, 0) },
+		func(text *Buf) { INSERTPS.RegRegImm8(text, Quad, 0, 1, 0) },
+		func(text *Buf) { PHADD.RegReg(text, Byte, 0, 1) },
+		func(text *Buf) { PABS.RegMemDisp(text, Quad, 0, 1, 0) },
+		func(text *Buf) { PMADDWD.RegReg(text, Long, 0, 1) },
+		func(text *Buf) { PMADDUBSW.RegReg(text, Word, 0, 1) },
+		func(text *Buf) { PSADBW.RegReg(text, Word, 0, 1) },
+		func(text *Buf) { PADDS.RegReg(text, Long, 0, 1) },
+		func(text *Buf) { PSUBUS.RegMemDisp(text, Quad, 0, 1, 0) },
+		func(text *Buf) { PAVG.RegReg(text, Octet, 0, 1) },
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		fn(text)
+		if len(text.Errors) != 1 || len(text.Bytes()) != 0 {
+			t.Errorf("errors=%v bytes=%x", text.Errors, text.Bytes())
+		}
+	}
+}
+
+func TestBlendvMaskError(t *testing.T) {
+	text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+	PBLENDV.RegRegMask(text, 0, 1, 2)
+	if len(text.Errors) != 1 || !strings.Contains(text.Errors[0].Error(), "must be XMM0") {
+		t.Errorf("errors=%v", text.Errors)
+	}
+}
@@ -0,0 +1,1019 @@
+// Copyright (c) 2018 Timo Savola. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package in
+
+import (
+	"github.com/pkg/errors"
+)
+
+type vexMap byte // VEX.mmmmm
+type vexPP byte  // VEX.pp
+type vexL byte   // VEX.L
+
+const (
+	vexMap0F   = vexMap(1)
+	vexMap0F38 = vexMap(2)
+	vexMap0F3A = vexMap(3)
+)
+
+const (
+	vexPPNone = vexPP(0)
+	vexPP66   = vexPP(1)
+	vexPPF3   = vexPP(2)
+	vexPPF2   = vexPP(3)
+)
+
+const (
+	vexL128 = vexL(0 << 2)
+	vexL256 = vexL(1 << 2)
+)
+
+const (
+	// VEX.vvvv is 1111b when the operand is unused, which is the same as the
+	// encoding of register 0.
+	vexNoReg = Reg(0)
+)
+
+func typePackedVexPP(t Type) vexPP { return vexPP(t >> 3) }   // none or 0x66
+func typeScalarVexPP(t Type) vexPP { return vexPP(t>>3) | 2 } // 0xf3 or 0xf2
+
+func prefixVexPP(prefix byte) vexPP {
+	switch prefix {
+	case 0x66:
+		return vexPP66
+	case 0xf3:
+		return vexPPF3
+	case 0xf2:
+		return vexPPF2
+	default:
+		return vexPPNone
+	}
+}
+
+func escapeVexMap(escape byte) vexMap {
+	switch escape {
+	case 0x38:
+		return vexMap0F38
+
+	case 0x3a:
+		return vexMap0F3A
+
+	default:
+		return vexMap0F
+	}
+}
+
+// widthVexL reports an error if the width cannot be encoded with VEX prefix.
+func widthVexL(text *Buf, w Width) (l vexL, ok bool) {
+	switch w {
+	case Width128:
+		return vexL128, true
+
+	case Width256:
+		return vexL256, true
+
+	default:
+		text.Err(errors.Errorf("missing VEX encoding for width=%v addr=%v", w, text.Addr))
+		return
+	}
+}
+
+// vex appends a 2-byte VEX prefix if possible, or a 3-byte VEX prefix.  REX
+// bits are inverted as required.
+func (o *output) vex(m vexMap, wrxb rexWRXB, v Reg, l vexL, pp vexPP) {
+	vlpp := byte(^v&15)<<3 | byte(l) | byte(pp)
+
+	if m == vexMap0F && wrxb&(RexW|RexX|RexB) == 0 {
+		o.byte(0xc5)
+		o.byte(byte(^wrxb&RexR)<<5 | vlpp)
+	} else {
+		o.byte(0xc4)
+		o.byte(byte(^wrxb&(RexR|RexX|RexB))<<5 | byte(m))
+		o.byte(byte(wrxb&RexW)<<4 | vlpp)
+	}
+}
+
+func errorNoVexNDS(text *Buf, family string, op interface{}) {
+	text.Err(errors.Errorf("missing three-operand encoding for %s op=%x addr=%v", family, op, text.Addr))
+}
+
+// NP with VEX prefix
+
+type NPvex uint16 // VEX.L and opcode byte
+
+func (op NPvex) Simple(text *Buf) {
+	var o output
+	o.vex(vexMap0F, 0, vexNoReg, vexL(op>>8), vexPPNone)
+	o.byte(byte(op))
+	o.copy(text.Extend(o.len()))
+}
+
+// RMprefixnt
+
+func (op RMprefixnt) vexNDS() bool {
+	switch byte(op) {
+	case 0x6f, 0x7f: // moves
+		return false
+
+	case 0x5a, 0x5b, 0xe6: // conversions
+		return false
+
+	default:
+		return true
+	}
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op RMprefixnt) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMprefixnt) vexRegReg(text *Buf, l vexL, r, v, r2 Reg) {
+	var o output
+	o.vex(vexMap0F, regRexR(r)|regRexB(r2), v, l, prefixVexPP(byte(op>>8)))
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMprefixnt) vexRegMemDisp(text *Buf, l vexL, r, v, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(vexMap0F, regRexR(r)|regRexB(base), v, l, prefixVexPP(byte(op>>8)))
+	o.byte(byte(op))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RMprefixnt) WidthRegReg(text *Buf, w Width, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, r, op.vexV(r), r2)
+	}
+}
+
+// WidthRegMemDisp encodes the VEX form of RegMemDisp.
+func (op RMprefixnt) WidthRegMemDisp(text *Buf, w Width, r, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, r, op.vexV(r), base, disp)
+	}
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMprefixnt) RegRegReg(text *Buf, w Width, r, r1, r2 Reg) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMprefixnt", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, r, r1, r2)
+	}
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMprefixnt) RegRegMemDisp(text *Buf, w Width, r, r1, base Reg, disp int32) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMprefixnt", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, r, r1, base, disp)
+	}
+}
+
+// RMprefix38nt
+
+func (op RMprefix38nt) vexNDS() bool {
+	switch byte(op) {
+	case 0x17: // PTEST
+		return false
+
+	default:
+		return true
+	}
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op RMprefix38nt) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMprefix38nt) vexRegReg(text *Buf, l vexL, r, v, r2 Reg) {
+	var o output
+	o.vex(vexMap0F38, regRexR(r)|regRexB(r2), v, l, prefixVexPP(byte(op>>8)))
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMprefix38nt) vexRegMemDisp(text *Buf, l vexL, r, v, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(vexMap0F38, regRexR(r)|regRexB(base), v, l, prefixVexPP(byte(op>>8)))
+	o.byte(byte(op))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RMprefix38nt) WidthRegReg(text *Buf, w Width, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, r, op.vexV(r), r2)
+	}
+}
+
+// WidthRegMemDisp encodes the VEX form of RegMemDisp.
+func (op RMprefix38nt) WidthRegMemDisp(text *Buf, w Width, r, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, r, op.vexV(r), base, disp)
+	}
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMprefix38nt) RegRegReg(text *Buf, w Width, r, r1, r2 Reg) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMprefix38nt", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, r, r1, r2)
+	}
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMprefix38nt) RegRegMemDisp(text *Buf, w Width, r, r1, base Reg, disp int32) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMprefix38nt", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, r, r1, base, disp)
+	}
+}
+
+// RMpacked
+
+func (op RMpacked) vexNDS() bool {
+	switch op {
+	case MOVUPSD, MOVUPSDmr, MOVAPSD, MOVAPSDmr, UCOMISSD, SQRTPSD:
+		return false
+
+	default:
+		return true
+	}
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op RMpacked) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMpacked) vexRegReg(text *Buf, l vexL, t Type, r, v, r2 Reg) {
+	var o output
+	o.vex(vexMap0F, regRexR(r)|regRexB(r2), v, l, typePackedVexPP(t))
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMpacked) vexRegMemDisp(text *Buf, seg Segment, abs bool, l vexL, t Type, r, v, base Reg, disp int32) {
+	var o output
+	o.segment(seg)
+	o.vex(vexMap0F, regRexR(r)|regRexB(base), v, l, typePackedVexPP(t))
+	o.byte(byte(op))
+	o.memDisp(abs, regRO(r), base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RMpacked) WidthRegReg(text *Buf, w Width, t Type, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, t, r, op.vexV(r), r2)
+	}
+}
+
+// WidthRegMemDisp encodes the VEX form of RegMemDisp.
+func (op RMpacked) WidthRegMemDisp(text *Buf, w Width, t Type, r, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, NoSegment, false, l, t, r, op.vexV(r), base, disp)
+	}
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMpacked) RegRegReg(text *Buf, w Width, t Type, r, r1, r2 Reg) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMpacked", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, t, r, r1, r2)
+	}
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMpacked) RegRegMemDisp(text *Buf, w Width, t Type, r, r1, base Reg, disp int32) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMpacked", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, NoSegment, false, l, t, r, r1, base, disp)
+	}
+}
+
+// RRprefixnt and RRpacked
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RRprefixnt) WidthRegReg(text *Buf, w Width, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		RMprefixnt(op).vexRegReg(text, l, r, vexNoReg, r2)
+	}
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RRpacked) WidthRegReg(text *Buf, w Width, t Type, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		RMpacked(op).vexRegReg(text, l, t, r, vexNoReg, r2)
+	}
+}
+
+// RMpackedsz
+
+func (op RMpackedsz) vexRegReg(text *Buf, l vexL, sz Size, r, v, r2 Reg) {
+	var o output
+	bop, ok := op.opByte(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMpackedsz op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(vexMap0F, regRexR(r)|regRexB(r2), v, l, vexPP66)
+	o.byte(bop)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMpackedsz) vexRegMemDisp(text *Buf, l vexL, sz Size, r, v, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	bop, ok := op.opByte(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMpackedsz op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(vexMap0F, regRexR(r)|regRexB(base), v, l, vexPP66)
+	o.byte(bop)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMpackedsz) RegRegReg(text *Buf, w Width, sz Size, r, r1, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, sz, r, r1, r2)
+	}
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMpackedsz) RegRegMemDisp(text *Buf, w Width, sz Size, r, r1, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, sz, r, r1, base, disp)
+	}
+}
+
+// RMscalar
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand register form.
+func (op RMscalar) vexV(r, r2 Reg) Reg {
+	switch op {
+	case CVTTSSD2SI:
+		return vexNoReg
+
+	case MOVSSDmr:
+		return r2 // upper part is merged from the destination
+
+	default:
+		return r
+	}
+}
+
+func (op RMscalar) vexMemNDS() bool {
+	switch op {
+	case MOVSSD, MOVSSDmr, CVTTSSD2SI:
+		return false
+
+	default:
+		return true
+	}
+}
+
+// vexMemV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand memory form.
+func (op RMscalar) vexMemV(r Reg) Reg {
+	if op.vexMemNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMscalar) vexRegReg(text *Buf, floatType, intType Type, r, v, r2 Reg) {
+	var o output
+	o.vex(vexMap0F, typeRexW(intType)|regRexR(r)|regRexB(r2), v, vexL128, typeScalarVexPP(floatType))
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMscalar) vexRegMemDisp(text *Buf, seg Segment, abs bool, t Type, r, v, base Reg, disp int32) {
+	var o output
+	o.segment(seg)
+	o.vex(vexMap0F, regRexR(r)|regRexB(base), v, vexL128, typeScalarVexPP(t))
+	o.byte(byte(op))
+	o.memDisp(abs, regRO(r), base, disp)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMscalar) RegRegReg(text *Buf, t Type, r, r1, r2 Reg) {
+	if op == CVTTSSD2SI {
+		errorNoVexNDS(text, "RMscalar", op)
+		return
+	}
+	op.vexRegReg(text, t, OneSize, r, r1, r2)
+}
+
+// TypeRegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMscalar) TypeRegRegReg(text *Buf, floatType, intType Type, r, r1, r2 Reg) {
+	if op == CVTTSSD2SI {
+		errorNoVexNDS(text, "RMscalar", op)
+		return
+	}
+	op.vexRegReg(text, floatType, intType, r, r1, r2)
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMscalar) RegRegMemDisp(text *Buf, t Type, r, r1, base Reg, disp int32) {
+	if !op.vexMemNDS() {
+		errorNoVexNDS(text, "RMscalar", op)
+		return
+	}
+	op.vexRegMemDisp(text, NoSegment, false, t, r, r1, base, disp)
+}
+
+// RMpackedsz38
+
+func (op RMpackedsz38) vexNDS() bool {
+	return op != PABS
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op RMpackedsz38) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMpackedsz38) vexOpcode(sz Size) (m vexMap, b byte, ok bool) {
+	w, ok := op.opWord(sz)
+	if byte(w) == 0x38 {
+		m = vexMap0F38
+		b = byte(w >> 8)
+	} else {
+		m = vexMap0F
+		b = byte(w)
+	}
+	return
+}
+
+func (op RMpackedsz38) vexRegReg(text *Buf, l vexL, sz Size, r, v, r2 Reg) {
+	var o output
+	m, b, ok := op.vexOpcode(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMpackedsz38 op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(m, regRexR(r)|regRexB(r2), v, l, vexPP66)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMpackedsz38) vexRegMemDisp(text *Buf, l vexL, sz Size, r, v, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	m, b, ok := op.vexOpcode(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMpackedsz38 op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(m, regRexR(r)|regRexB(base), v, l, vexPP66)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op RMpackedsz38) WidthRegReg(text *Buf, w Width, sz Size, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, sz, r, op.vexV(r), r2)
+	}
+}
+
+// WidthRegMemDisp encodes the VEX form of RegMemDisp.
+func (op RMpackedsz38) WidthRegMemDisp(text *Buf, w Width, sz Size, r, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, sz, r, op.vexV(r), base, disp)
+	}
+}
+
+// RegRegReg encodes the VEX form with non-destructive source operand r1.
+func (op RMpackedsz38) RegRegReg(text *Buf, w Width, sz Size, r, r1, r2 Reg) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMpackedsz38", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, sz, r, r1, r2)
+	}
+}
+
+// RegRegMemDisp encodes the VEX form with non-destructive source operand r1.
+func (op RMpackedsz38) RegRegMemDisp(text *Buf, w Width, sz Size, r, r1, base Reg, disp int32) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMpackedsz38", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, sz, r, r1, base, disp)
+	}
+}
+
+// RMblendv
+
+func (op RMblendv) vexRegRegMask(text *Buf, l vexL, r, v, r2, mask Reg) {
+	var o output
+	o.vex(vexMap0F3A, regRexR(r)|regRexB(r2), v, l, vexPP66)
+	o.byte(byte(op >> 8))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.byte(byte(mask) << 4)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMblendv) vexRegMemDispMask(text *Buf, l vexL, r, v, base Reg, disp int32, mask Reg) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(vexMap0F3A, regRexR(r)|regRexB(base), v, l, vexPP66)
+	o.byte(byte(op >> 8))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.byte(byte(mask) << 4)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegRegMask encodes the VEX form with non-destructive source operand r1.
+// The mask may be any register.
+func (op RMblendv) RegRegRegMask(text *Buf, w Width, r, r1, r2, mask Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegMask(text, l, r, r1, r2, mask)
+	}
+}
+
+// RegRegMemDispMask encodes the VEX form with non-destructive source operand
+// r1.  The mask may be any register.
+func (op RMblendv) RegRegMemDispMask(text *Buf, w Width, r, r1, base Reg, disp int32, mask Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispMask(text, l, r, r1, base, disp, mask)
+	}
+}
+
+// PMovx
+
+func (op PMovx) vexRegReg(text *Buf, l vexL, from, to Size, r, r2 Reg) {
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.vex(vexMap0F38, regRexR(r)|regRexB(r2), vexNoReg, l, vexPP66)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PMovx) vexRegMemDisp(text *Buf, l vexL, from, to Size, r, base Reg, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.vex(vexMap0F38, regRexR(r)|regRexB(base), vexNoReg, l, vexPP66)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PMovx) vexRegMemIndexDisp(text *Buf, l vexL, from, to Size, r, base, index Reg, s Scale, disp int32) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	b, ok := op.opByte(from, to)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PMovx op=%x from=%v to=%v addr=%v", op, from, to, text.Addr))
+		return
+	}
+	o.vex(vexMap0F38, regRexR(r)|regRexX(index)|regRexB(base), vexNoReg, l, vexPP66)
+	o.byte(b)
+	o.mod(mod, regRO(r), ModRMSIB)
+	o.sib(s, regIndex(index), regBase(base))
+	o.int(disp, dispSize)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegReg encodes the VEX form of RegReg.
+func (op PMovx) WidthRegReg(text *Buf, w Width, from, to Size, r, r2 Reg) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegReg(text, l, from, to, r, r2)
+	}
+}
+
+// WidthRegMemDisp encodes the VEX form of RegMemDisp.
+func (op PMovx) WidthRegMemDisp(text *Buf, w Width, from, to Size, r, base Reg, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDisp(text, l, from, to, r, base, disp)
+	}
+}
+
+// WidthRegMemIndexDisp encodes the VEX form of RegMemIndexDisp.
+func (op PMovx) WidthRegMemIndexDisp(text *Buf, w Width, from, to Size, r, base, index Reg, s Scale, disp int32) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemIndexDisp(text, l, from, to, r, base, index, s, disp)
+	}
+}
+
+// RMIpackedsz
+
+func (op RMIpackedsz) vexRegRegImm8(text *Buf, l vexL, sz Size, r, r2 Reg, val int8) {
+	var o output
+	b, ro, ok := op.opRoBytes(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMIpackedsz op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(vexMap0F, regRexB(r2), r, l, vexPP66)
+	o.byte(b)
+	o.mod(ModReg, ModRO(ro), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegImm8 encodes the VEX form with destination r and non-destructive
+// source operand r2.
+func (op RMIpackedsz) RegRegImm8(text *Buf, w Width, sz Size, r, r2 Reg, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, sz, r, r2, val)
+	}
+}
+
+// PBlendi
+
+func (op PBlendi) vexRegRegImm8(text *Buf, l vexL, sz Size, r, v, r2 Reg, val int8) {
+	var o output
+	b, ok := op.opByte(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PBlendi op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(vexMap0F3A, regRexR(r)|regRexB(r2), v, l, vexPP66)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PBlendi) vexRegMemDispImm8(text *Buf, l vexL, sz Size, r, v, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	b, ok := op.opByte(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for PBlendi op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(vexMap0F3A, regRexR(r)|regRexB(base), v, l, vexPP66)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegRegImm8 encodes the VEX form with non-destructive source operand r1.
+func (op PBlendi) RegRegRegImm8(text *Buf, w Width, sz Size, r, r1, r2 Reg, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, sz, r, r1, r2, val)
+	}
+}
+
+// RegRegMemDispImm8 encodes the VEX form with non-destructive source operand
+// r1.
+func (op PBlendi) RegRegMemDispImm8(text *Buf, w Width, sz Size, r, r1, base Reg, disp int32, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispImm8(text, l, sz, r, r1, base, disp, val)
+	}
+}
+
+// PShufi
+
+func (op PShufi) vexOpcode() (m vexMap, pp vexPP, b byte) {
+	m = vexMap0F
+	if op[len(op)-2] == 0x3a {
+		m = vexMap0F3A
+	}
+	pp = prefixVexPP(op[0])
+	b = op[len(op)-1]
+	return
+}
+
+// vexNDS is true for SHUFPS, SHUFPD and PALIGNR.
+func (op PShufi) vexNDS() bool {
+	return op[len(op)-1] == 0xc6 || op[len(op)-2] == 0x3a
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op PShufi) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op PShufi) vexRegRegImm8(text *Buf, l vexL, r, v, r2 Reg, val int8) {
+	var o output
+	m, pp, b := op.vexOpcode()
+	o.vex(m, regRexR(r)|regRexB(r2), v, l, pp)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op PShufi) vexRegMemDispImm8(text *Buf, l vexL, r, v, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	m, pp, b := op.vexOpcode()
+	o.vex(m, regRexR(r)|regRexB(base), v, l, pp)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegRegImm8 encodes the VEX form of RegRegImm8.
+func (op PShufi) WidthRegRegImm8(text *Buf, w Width, r, r2 Reg, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, r, op.vexV(r), r2, val)
+	}
+}
+
+// WidthRegMemDispImm8 encodes the VEX form of RegMemDispImm8.
+func (op PShufi) WidthRegMemDispImm8(text *Buf, w Width, r, base Reg, disp int32, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispImm8(text, l, r, op.vexV(r), base, disp, val)
+	}
+}
+
+// RegRegRegImm8 encodes the VEX form with non-destructive source operand r1.
+func (op PShufi) RegRegRegImm8(text *Buf, w Width, r, r1, r2 Reg, val int8) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "PShufi", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, r, r1, r2, val)
+	}
+}
+
+// RegRegMemDispImm8 encodes the VEX form with non-destructive source operand
+// r1.
+func (op PShufi) RegRegMemDispImm8(text *Buf, w Width, r, r1, base Reg, disp int32, val int8) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "PShufi", op)
+		return
+	}
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispImm8(text, l, r, r1, base, disp, val)
+	}
+}
+
+// CmpPacked
+
+func (op CmpPacked) vexRegRegImm8(text *Buf, l vexL, t Type, r, v, r2 Reg, pred CmpPredicate) {
+	var o output
+	o.vex(vexMap0F, regRexR(r)|regRexB(r2), v, l, typePackedVexPP(t))
+	o.byte(byte(op))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(int8(pred))
+	o.copy(text.Extend(o.len()))
+}
+
+func (op CmpPacked) vexRegMemDispImm8(text *Buf, l vexL, t Type, r, v, base Reg, disp int32, pred CmpPredicate) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(vexMap0F, regRexR(r)|regRexB(base), v, l, typePackedVexPP(t))
+	o.byte(byte(op))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(int8(pred))
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegRegImm8 encodes the VEX form with non-destructive source operand r1.
+func (op CmpPacked) RegRegRegImm8(text *Buf, w Width, t Type, r, r1, r2 Reg, pred CmpPredicate) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, t, r, r1, r2, pred)
+	}
+}
+
+// RegRegMemDispImm8 encodes the VEX form with non-destructive source operand
+// r1.
+func (op CmpPacked) RegRegMemDispImm8(text *Buf, w Width, t Type, r, r1, base Reg, disp int32, pred CmpPredicate) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispImm8(text, l, t, r, r1, base, disp, pred)
+	}
+}
+
+// RMIlane
+
+func (op RMIlane) vexOpcode(sz Size) (m vexMap, b byte, ok bool) {
+	w, ok := RMpackedsz38(op).opWord(sz)
+	if byte(w) == 0x3a {
+		m = vexMap0F3A
+		b = byte(w >> 8)
+	} else {
+		m = vexMap0F
+		b = byte(w)
+	}
+	return
+}
+
+// vexNDS is true for inserts.
+func (op RMIlane) vexNDS() bool {
+	switch op {
+	case PINSR, INSERTPS:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// vexV returns the VEX.vvvv operand corresponding to the destructive
+// two-operand form.
+func (op RMIlane) vexV(r Reg) Reg {
+	if op.vexNDS() {
+		return r
+	}
+	return vexNoReg
+}
+
+func (op RMIlane) vexRegRegImm8(text *Buf, sz Size, r, v, r2 Reg, val int8) {
+	var o output
+	m, b, ok := op.vexOpcode(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMIlane op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(m, laneRexW(sz)|regRexR(r)|regRexB(r2), v, vexL128, vexPP66)
+	o.byte(b)
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIlane) vexRegMemDispImm8(text *Buf, sz Size, r, v, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	m, b, ok := op.vexOpcode(sz)
+	if !ok {
+		text.Err(errors.Errorf("missing encoding for RMIlane op=%x size=%v addr=%v", op, sz, text.Addr))
+		return
+	}
+	o.vex(m, laneRexW(sz)|regRexR(r)|regRexB(base), v, vexL128, vexPP66)
+	o.byte(b)
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegRegImm8 encodes the VEX form with non-destructive source operand r1.
+func (op RMIlane) RegRegRegImm8(text *Buf, sz Size, r, r1, r2 Reg, val int8) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMIlane", op)
+		return
+	}
+	op.vexRegRegImm8(text, sz, r, r1, r2, val)
+}
+
+// RegRegMemDispImm8 encodes the VEX form with non-destructive source operand
+// r1.
+func (op RMIlane) RegRegMemDispImm8(text *Buf, sz Size, r, r1, base Reg, disp int32, val int8) {
+	if !op.vexNDS() {
+		errorNoVexNDS(text, "RMIlane", op)
+		return
+	}
+	op.vexRegMemDispImm8(text, sz, r, r1, base, disp, val)
+}
+
+// RMIscalar
+
+func (op RMIscalar) vexRegRegImm8(text *Buf, t Type, r, v, r2 Reg, val int8) {
+	var o output
+	o.vex(escapeVexMap(byte(op)), regRexR(r)|regRexB(r2), v, vexL128, vexPP66)
+	o.byte(typeRMISizeCode(t))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIscalar) vexRegMemDispImm8(text *Buf, t Type, r, v, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(escapeVexMap(byte(op)), regRexR(r)|regRexB(base), v, vexL128, vexPP66)
+	o.byte(typeRMISizeCode(t))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// RegRegRegImm8 encodes the VEX form with non-destructive source operand r1.
+func (op RMIscalar) RegRegRegImm8(text *Buf, t Type, r, r1, r2 Reg, val int8) {
+	op.vexRegRegImm8(text, t, r, r1, r2, val)
+}
+
+// RegRegMemDispImm8 encodes the VEX form with non-destructive source operand
+// r1.
+func (op RMIscalar) RegRegMemDispImm8(text *Buf, t Type, r, r1, base Reg, disp int32, val int8) {
+	op.vexRegMemDispImm8(text, t, r, r1, base, disp, val)
+}
+
+// RMIpacked
+
+func (op RMIpacked) vexRegRegImm8(text *Buf, l vexL, t Type, r, r2 Reg, val int8) {
+	var o output
+	o.vex(escapeVexMap(byte(op)), regRexR(r)|regRexB(r2), vexNoReg, l, vexPP66)
+	o.byte(typeRMIPackedCode(t))
+	o.mod(ModReg, regRO(r), regRM(r2))
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+func (op RMIpacked) vexRegMemDispImm8(text *Buf, l vexL, t Type, r, base Reg, disp int32, val int8) {
+	var mod, dispSize = dispModSize(disp)
+	var o output
+	o.vex(escapeVexMap(byte(op)), regRexR(r)|regRexB(base), vexNoReg, l, vexPP66)
+	o.byte(typeRMIPackedCode(t))
+	o.mod(mod, regRO(r), regRM(base))
+	o.int(disp, dispSize)
+	o.int8(val)
+	o.copy(text.Extend(o.len()))
+}
+
+// WidthRegRegImm8 encodes the VEX form of RegRegImm8.
+func (op RMIpacked) WidthRegRegImm8(text *Buf, w Width, t Type, r, r2 Reg, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegRegImm8(text, l, t, r, r2, val)
+	}
+}
+
+// WidthRegMemDispImm8 encodes the VEX form of RegMemDispImm8.
+func (op RMIpacked) WidthRegMemDispImm8(text *Buf, w Width, t Type, r, base Reg, disp int32, val int8) {
+	if l, ok := widthVexL(text, w); ok {
+		op.vexRegMemDispImm8(text, l, t, r, base, disp, val)
+	}
+}
@@ -0,0 +1,418 @@
+package in
+
+import (
+	"fmt"
+	"github.com/tsavola/wag/buffer"
+	"golang.org/x/arch/x86/x86asm"
+	"testing"
+)
+
+var testGPRegs64 = [16]string{
+	"RAX", "RCX", "RDX", "RBX", "RSP", "RBP", "RSI", "RDI",
+	"R8", "R9", "R10", "R11", "R12", "R13", "R14", "R15",
+}
+
+var testGPRegs32 = [16]string{
+	"EAX", "ECX", "EDX", "EBX", "ESP", "EBP", "ESI", "EDI",
+	"R8L", "R9L", "R10L", "R11L", "R12L", "R13L", "R14L", "R15L",
+}
+
+func TestVEXInstructions(t *testing.T) {
+	for i := 0; i <= 15; i++ {
+		for j := 0; j <= 15; j++ {
+			k := (i + j + 1) & 15
+			xi := fmt.Sprintf("X%d", i)
+			xj := fmt.Sprintf("X%d", j)
+			xk := fmt.Sprintf("X%d", k)
+			ri, rj, rk := Reg(i), Reg(j), Reg(k)
+
+			// Three-operand forms:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegRegReg(text, Width128, Byte, ri, rk, rj) }), x86asm.VPADDB, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegRegReg(text, Width128, Quad, ri, rk, rj) }), x86asm.VPSUBQ, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRA.RegRegReg(text, Width128, Long, ri, rk, rj) }), x86asm.VPSRAD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDNPSD.RegRegReg(text, Width128, F32, ri, rk, rj) }), x86asm.VANDNPS, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { XORPSD.RegRegReg(text, Width128, F64, ri, rk, rj) }), x86asm.VXORPD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADDSSD.RegRegReg(text, F32, ri, rk, rj) }), x86asm.VADDSS, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { DIVSSD.RegRegReg(text, F64, ri, rk, rj) }), x86asm.VDIVSD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTSI2SSD.TypeRegRegReg(text, F64, I64, ri, rk, rj) }),
+				x86asm.VCVTSI2SD, xi, xk, testGPRegs64[j])
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINS.RegRegReg(text, Width128, Byte, ri, rk, rj) }), x86asm.VPMINSB, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINS.RegRegReg(text, Width128, Word, ri, rk, rj) }), x86asm.VPMINSW, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXU.RegRegReg(text, Width128, Long, ri, rk, rj) }), x86asm.VPMAXUD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegRegImm8(text, Width128, Word, ri, rk, rj, 0x04) }),
+				x86asm.VPBLENDW, xi, xk, xj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPSi.RegRegRegImm8(text, Width128, ri, rk, rj, 0x04) }),
+				x86asm.VSHUFPS, xi, xk, xj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPDi.RegRegRegImm8(text, Width128, ri, rk, rj, 0x04) }),
+				x86asm.VSHUFPD, xi, xk, xj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegRegReg(text, Width128, Quad, ri, rk, rj) }), x86asm.VPCMPEQQ, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegRegReg(text, Width128, Byte, ri, rk, rj) }), x86asm.VPCMPGTB, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PANDN.RegRegReg(text, Width128, ri, rk, rj) }), x86asm.VPANDN, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ADDPSD.RegRegReg(text, Width128, F32, ri, rk, rj) }), x86asm.VADDPS, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MAXPSD.RegRegReg(text, Width128, F64, ri, rk, rj) }), x86asm.VMAXPD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegRegRegImm8(text, Width128, F64, ri, rk, rj, CmpPredicateNEQ) }),
+				x86asm.VCMPPD, xi, xk, xj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRLi.RegRegImm8(text, Width128, Word, ri, rj, 0x4) }), x86asm.VPSRLW, xi, xj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLLi.RegRegImm8(text, Width128, Octet, ri, rj, 0x4) }), x86asm.VPSLLDQ, xi, xj, "0x4")
+
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PHADD.RegRegReg(text, Width128, Word, ri, rk, rj) }), x86asm.VPHADDW, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDWD.RegRegReg(text, Width128, Word, ri, rk, rj) }), x86asm.VPMADDWD, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMADDUBSW.RegRegReg(text, Width128, Byte, ri, rk, rj) }), x86asm.VPMADDUBSW, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegRegRegImm8(text, F64, ri, rk, rj, RoundModeFloor) }),
+				x86asm.VROUNDSD, xi, xk, xj, "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFB.RegRegReg(text, Width128, ri, rk, rj) }), x86asm.VPSHUFB, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PALIGNRi.RegRegRegImm8(text, Width128, ri, rk, rj, 8) }),
+				x86asm.VPALIGNR, xi, xk, xj, "0x8")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKH.RegRegReg(text, Width128, Byte, ri, rk, rj) }), x86asm.VPUNPCKHBW, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PACKUS.RegRegReg(text, Width128, Long, ri, rk, rj) }), x86asm.VPACKUSDW, xi, xk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDV.RegRegRegMask(text, Width128, ri, rk, rj, Reg(15-j)) }),
+				x86asm.VPBLENDVB, xi, xk, xj, fmt.Sprintf("X%d", 15-j))
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPS.RegRegRegMask(text, Width128, ri, rk, rj, Reg(i)) }),
+				x86asm.VBLENDVPS, xi, xk, xj, xi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegRegImm8(text, Byte, ri, rk, rj, 15) }),
+				x86asm.VPINSRB, xi, xk, testGPRegs32[j], "0xf")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegRegImm8(text, Word, ri, rk, rj, 7) }),
+				x86asm.VPINSRW, xi, xk, testGPRegs32[j], "0x7")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegRegImm8(text, Quad, ri, rk, rj, 1) }),
+				x86asm.VPINSRQ, xi, xk, testGPRegs64[j], "0x1")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { INSERTPS.RegRegRegImm8(text, Long, ri, rk, rj, 0x30) }),
+				x86asm.VINSERTPS, xi, xk, xj, "0x30")
+
+			// Two-operand forms encoded with VEX prefix:
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PADD.RegReg(text, Word, ri, rj) }), x86asm.VPADDW, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { ORPSD.RegReg(text, F32, ri, rj) }), x86asm.VORPS, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVAPSD.RegReg(text, F64, ri, rj) }), x86asm.VMOVAPD, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { UCOMISSD.RegReg(text, F32, ri, rj) }), x86asm.VUCOMISS, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { SQRTSSD.RegReg(text, F64, ri, rj) }), x86asm.VSQRTSD, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVSSDmr.RegReg(text, F32, ri, rj) }), x86asm.VMOVSS, xj, xj, xi)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CVTTSSD2SI.TypeRegReg(text, F64, I64, ri, rj) }),
+				x86asm.VCVTTSD2SI, testGPRegs64[i], xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CVTSI2SSD.TypeRegReg(text, F32, I32, ri, rj) }),
+				x86asm.VCVTSI2SS, xi, xi, testGPRegs32[j])
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMAXS.RegReg(text, Word, ri, rj) }), x86asm.VPMAXSW, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PBLENDi.RegRegImm8(text, Long, ri, rj, 0x04) }),
+				x86asm.VBLENDPS, xi, xi, xj, "0x4")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSHUFDi.RegRegImm8(text, ri, rj, 0x04) }),
+				x86asm.VPSHUFD, xi, xj, "0x4")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { SHUFPSi.RegRegImm8(text, ri, rj, 0x04) }),
+				x86asm.VSHUFPS, xi, xi, xj, "0x4")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSRAi.RegImm8(text, Long, ri, 0x4) }), x86asm.VPSRAD, xi, xi, "0x4")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { POR.RegReg(text, ri, rj) }), x86asm.VPOR, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PXOR.RegReg(text, ri, rj) }), x86asm.VPXOR, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { SQRTPSD.RegReg(text, F32, ri, rj) }), x86asm.VSQRTPS, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { DIVPSD.RegReg(text, F64, ri, rj) }), x86asm.VDIVPD, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CMPPSD.RegRegImm8(text, F32, ri, rj, CmpPredicateUNORD) }),
+				x86asm.VCMPPS, xi, xi, xj, "0x3")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PTEST.RegReg(text, ri, rj) }), x86asm.VPTEST, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CVTDQ2PS.RegReg(text, ri, rj) }), x86asm.VCVTDQ2PS, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CVTTPD2DQ.RegReg(text, ri, rj) }), x86asm.VCVTTPD2DQ, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMOVSX.RegReg(text, Word, Quad, ri, rj) }), x86asm.VPMOVSXWQ, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PABS.RegReg(text, Long, ri, rj) }), x86asm.VPABSD, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { ROUNDSSD.RegRegImm8(text, F32, ri, rj, RoundModeCeil) }),
+				x86asm.VROUNDSS, xi, xi, xj, "0x2")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { ROUNDPSD.RegRegImm8(text, F64, ri, rj, RoundModeTrunc) }),
+				x86asm.VROUNDPD, xi, xj, "0x3")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSIGN.RegReg(text, Word, ri, rj) }), x86asm.VPSIGNW, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMOVMSKB.RegReg(text, ri, rj) }), x86asm.VPMOVMSKB, testGPRegs32[i], xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { BLENDVPD.RegRegMask(text, ri, rj, 0) }), x86asm.VBLENDVPD, xi, xi, xj, "X0")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVMSKPSD.RegReg(text, F64, ri, rj) }), x86asm.VMOVMSKPD, testGPRegs32[i], xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSHUFB.RegReg(text, ri, rj) }), x86asm.VPSHUFB, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PALIGNRi.RegRegImm8(text, ri, rj, 8) }), x86asm.VPALIGNR, xi, xi, xj, "0x8")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PACKSS.RegReg(text, Word, ri, rj) }), x86asm.VPACKSSWB, xi, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PINSR.RegRegImm8(text, Long, ri, rj, 3) }),
+				x86asm.VPINSRD, xi, xi, testGPRegs32[j], "0x3")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PEXTR.RegRegImm8(text, Quad, ri, rj, 1) }),
+				x86asm.VPEXTRQ, testGPRegs64[j], xi, "0x1")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { EXTRACTPS.RegRegImm8(text, Long, ri, rj, 2) }),
+				x86asm.VEXTRACTPS, testGPRegs32[j], xi, "0x2")
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVOA.RegReg(text, ri, rj) }), x86asm.VMOVDQA, xi, xj)
+			checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVOUmr.RegReg(text, ri, rj) }), x86asm.VMOVDQU, xj, xi)
+		}
+	}
+
+	for i := 0; i <= 15; i++ {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			k := (i + base + 1) & 15
+			xi := fmt.Sprintf("X%d", i)
+			xk := fmt.Sprintf("X%d", k)
+			ri, rk, rb := Reg(i), Reg(k), Reg(base)
+
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "-0x1000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegRegMemDisp(text, Width128, Long, ri, rk, rb, disp) }),
+					x86asm.VPADDD, xi, xk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MULSSD.RegRegMemDisp(text, F32, ri, rk, rb, disp) }),
+					x86asm.VMULSS, xi, xk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDPSD.RegRegMemDisp(text, Width128, F64, ri, rk, rb, disp) }),
+					x86asm.VANDPD, xi, xk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMINU.RegRegMemDisp(text, Width128, Word, ri, rk, rb, disp) }),
+					x86asm.VPMINUW, xi, xk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegMemDispImm8(text, Width128, Quad, ri, rk, rb, disp, 0x04) }),
+					x86asm.VBLENDPD, xi, xk, m, "0x4")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPDi.RegRegMemDispImm8(text, Width128, ri, rk, rb, disp, 0x04) }),
+					x86asm.VSHUFPD, xi, xk, m, "0x4")
+
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVSSD.RegMemDisp(text, F64, ri, rb, disp) }),
+					x86asm.VMOVSD, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { SUBSSD.RegMemDisp(text, F32, ri, rb, disp) }),
+					x86asm.VSUBSS, xi, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { MOVUPSD.RegMemDisp(text, F32, ri, rb, disp) }),
+					x86asm.VMOVUPS, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSUB.RegMemDisp(text, Byte, ri, rb, disp) }),
+					x86asm.VPSUBB, xi, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CMPPSD.RegMemDispImm8(text, F64, ri, rb, disp, CmpPredicateLE) }),
+					x86asm.VCMPPD, xi, xi, m, "0x2")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { CVTPS2PD.RegMemDisp(text, ri, rb, disp) }),
+					x86asm.VCVTPS2PD, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PINSR.RegRegMemDispImm8(text, Byte, ri, rk, rb, disp, 15) }),
+					x86asm.VPINSRB, xi, xk, m, "0xf")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PEXTR.RegMemDispImm8(text, Word, ri, rb, disp, 7) }),
+					x86asm.VPEXTRW, m, xi, "0x7")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMOVZX.RegMemDisp(text, Byte, Word, ri, rb, disp) }),
+					x86asm.VPMOVZXBW, xi, m)
+				index := (base + 7) & 15 // never RSP
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMOVSX.RegMemIndexDisp(text, Word, Long, ri, rb, Reg(index), Scale1, disp) }),
+					x86asm.VPMOVSXWD, xi, "["+testGPRegs64[base]+"+2*"+testGPRegs64[index]+dispStr+"]")
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPS.RegRegMemDispMask(text, Width128, ri, rk, rb, disp, Reg(k)) }),
+					x86asm.VBLENDVPS, xi, xk, m, xk)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PBLENDV.RegMemDispMask(text, ri, rb, disp, 0) }),
+					x86asm.VPBLENDVB, xi, xi, m, "X0")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PABS.RegMemDisp(text, Byte, ri, rb, disp) }),
+					x86asm.VPABSB, xi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDSSD.RegRegMemDispImm8(text, F32, ri, rk, rb, disp, RoundModeTrunc) }),
+					x86asm.VROUNDSS, xi, xk, m, "0x3")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { ROUNDPSD.RegMemDispImm8(text, F32, ri, rb, disp, RoundModeFloor) }),
+					x86asm.VROUNDPS, xi, m, "0x1")
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PTEST.RegMemDisp(text, ri, rb, disp) }),
+					x86asm.VPTEST, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PMAXS.RegMemDisp(text, Byte, ri, rb, disp) }),
+					x86asm.VPMAXSB, xi, xi, m)
+				checkTestInst(t, encodeTestInstVEX(t, func(text *Buf) { PSHUFLWi.RegMemDispImm8(text, ri, rb, disp, 0x04) }),
+					x86asm.VPSHUFLW, xi, m, "0x4")
+			}
+		}
+	}
+}
+
+func TestVEX256Instructions(t *testing.T) {
+	for i := 0; i <= 15; i++ {
+		for j := 0; j <= 15; j++ {
+			k := (i + j + 1) & 15
+			yi := fmt.Sprintf("Y%d", i)
+			yj := fmt.Sprintf("Y%d", j)
+			yk := fmt.Sprintf("Y%d", k)
+			xi := fmt.Sprintf("X%d", i)
+			xj := fmt.Sprintf("X%d", j)
+			ri, rj, rk := Reg(i), Reg(j), Reg(k)
+
+			// Moves:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOA.WidthRegReg(text, Width256, ri, rj) }), x86asm.VMOVDQA, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOU.WidthRegReg(text, Width256, ri, rj) }), x86asm.VMOVDQU, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOAmr.WidthRegReg(text, Width256, ri, rj) }), x86asm.VMOVDQA, yj, yi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOUmr.WidthRegReg(text, Width256, ri, rj) }), x86asm.VMOVDQU, yj, yi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVAPSD.WidthRegReg(text, Width256, F32, ri, rj) }), x86asm.VMOVAPS, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSDmr.WidthRegReg(text, Width256, F64, ri, rj) }), x86asm.VMOVUPD, yj, yi)
+
+			// Arithmetic:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegRegReg(text, Width256, Byte, ri, rk, rj) }), x86asm.VPADDB, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegRegReg(text, Width256, Quad, ri, rk, rj) }), x86asm.VPADDQ, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegRegReg(text, Width256, Word, ri, rk, rj) }), x86asm.VPSUBW, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSUB.RegRegReg(text, Width256, Long, ri, rk, rj) }), x86asm.VPSUBD, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSLL.RegRegReg(text, Width256, Long, ri, rk, rj) }), x86asm.VPSLLD, yi, yk, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMAXS.RegRegReg(text, Width256, Byte, ri, rk, rj) }), x86asm.VPMAXSB, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSRAi.RegRegImm8(text, Width256, Word, ri, rj, 0x4) }), x86asm.VPSRAW, yi, yj, "0x4")
+
+			// Logic:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PAND.RegRegReg(text, Width256, ri, rk, rj) }), x86asm.VPAND, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PXOR.RegRegReg(text, Width256, ri, ri, ri) }), x86asm.VPXOR, yi, yi, yi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPEQ.RegRegReg(text, Width256, Word, ri, rk, rj) }), x86asm.VPCMPEQW, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PCMPGT.RegRegReg(text, Width256, Quad, ri, rk, rj) }), x86asm.VPCMPGTQ, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PTEST.WidthRegReg(text, Width256, ri, rj) }), x86asm.VPTEST, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFB.RegRegReg(text, Width256, ri, rk, rj) }), x86asm.VPSHUFB, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PALIGNRi.RegRegRegImm8(text, Width256, ri, rk, rj, 8) }),
+				x86asm.VPALIGNR, yi, yk, yj, "0x8")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PUNPCKL.RegRegReg(text, Width256, Quad, ri, rk, rj) }), x86asm.VPUNPCKLQDQ, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVZX.WidthRegReg(text, Width256, Byte, Word, ri, rj) }), x86asm.VPMOVZXBW, yi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PMOVMSKB.WidthRegReg(text, Width256, ri, rj) }), x86asm.VPMOVMSKB, testGPRegs32[i], yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PABS.WidthRegReg(text, Width256, Word, ri, rj) }), x86asm.VPABSW, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ROUNDPSD.WidthRegRegImm8(text, Width256, F32, ri, rj, RoundModeNearest) }),
+				x86asm.VROUNDPS, yi, yj, "0x0")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSADBW.RegRegReg(text, Width256, Byte, ri, rk, rj) }), x86asm.VPSADBW, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { BLENDVPD.RegRegRegMask(text, Width256, ri, rk, rj, Reg(i)) }),
+				x86asm.VBLENDVPD, yi, yk, yj, yi)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVMSKPSD.WidthRegReg(text, Width256, F32, ri, rj) }), x86asm.VMOVMSKPS, testGPRegs32[i], yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTTPS2DQ.WidthRegReg(text, Width256, ri, rj) }), x86asm.VCVTTPS2DQ, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTDQ2PD.WidthRegReg(text, Width256, ri, rj) }), x86asm.VCVTDQ2PD, yi, xj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CVTPD2PS.WidthRegReg(text, Width256, ri, rj) }), x86asm.VCVTPD2PS, xi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { MULPSD.RegRegReg(text, Width256, F32, ri, rk, rj) }), x86asm.VMULPS, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SUBPSD.RegRegReg(text, Width256, F64, ri, rk, rj) }), x86asm.VSUBPD, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SQRTPSD.WidthRegReg(text, Width256, F64, ri, rj) }), x86asm.VSQRTPD, yi, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { CMPPSD.RegRegRegImm8(text, Width256, F32, ri, rk, rj, CmpPredicateNLT) }),
+				x86asm.VCMPPS, yi, yk, yj, "0x5")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDPSD.RegRegReg(text, Width256, F32, ri, rk, rj) }), x86asm.VANDPS, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ANDNPSD.RegRegReg(text, Width256, F64, ri, rk, rj) }), x86asm.VANDNPD, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { ORPSD.RegRegReg(text, Width256, F32, ri, rk, rj) }), x86asm.VORPS, yi, yk, yj)
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { XORPSD.RegRegReg(text, Width256, F64, ri, rk, rj) }), x86asm.VXORPD, yi, yk, yj)
+
+			// Shuffles and blends:
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFDi.WidthRegRegImm8(text, Width256, ri, rj, 0x04) }),
+				x86asm.VPSHUFD, yi, yj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFHWi.WidthRegRegImm8(text, Width256, ri, rj, 0x04) }),
+				x86asm.VPSHUFHW, yi, yj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { SHUFPSi.RegRegRegImm8(text, Width256, ri, rk, rj, 0x04) }),
+				x86asm.VSHUFPS, yi, yk, yj, "0x4")
+			checkTestInst(t, encodeTestInst(t, func(text *Buf) { PBLENDi.RegRegRegImm8(text, Width256, Word, ri, rk, rj, 0x04) }),
+				x86asm.VPBLENDW, yi, yk, yj, "0x4")
+		}
+	}
+
+	for i := 0; i <= 15; i++ {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			k := (i + base + 1) & 15
+			yi := fmt.Sprintf("Y%d", i)
+			yk := fmt.Sprintf("Y%d", k)
+			ri, rk, rb := Reg(i), Reg(k), Reg(base)
+
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "-0x1000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOU.WidthRegMemDisp(text, Width256, ri, rb, disp) }),
+					x86asm.VMOVDQU, yi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVOAmr.WidthRegMemDisp(text, Width256, ri, rb, disp) }),
+					x86asm.VMOVDQA, m, yi)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { MOVUPSD.WidthRegMemDisp(text, Width256, F32, ri, rb, disp) }),
+					x86asm.VMOVUPS, yi, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PADD.RegRegMemDisp(text, Width256, Word, ri, rk, rb, disp) }),
+					x86asm.VPADDW, yi, yk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { XORPSD.RegRegMemDisp(text, Width256, F32, ri, rk, rb, disp) }),
+					x86asm.VXORPS, yi, yk, m)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { PSHUFDi.WidthRegMemDispImm8(text, Width256, ri, rb, disp, 0x04) }),
+					x86asm.VPSHUFD, yi, m, "0x4")
+			}
+		}
+	}
+
+	checkTestInst(t, encodeTestInst(t, func(text *Buf) { VZEROUPPER.Simple(text) }), x86asm.VZEROUPPER)
+	checkTestInst(t, encodeTestInst(t, func(text *Buf) { VZEROALL.Simple(text) }), x86asm.VZEROALL)
+}
+
+func TestFMAInstructions(t *testing.T) {
+	scalar := []struct {
+		op     FMAscalar
+		ss, sd x86asm.Op
+	}{
+		{VFMADD132SSD, x86asm.VFMADD132SS, x86asm.VFMADD132SD},
+		{VFMADD213SSD, x86asm.VFMADD213SS, x86asm.VFMADD213SD},
+		{VFMADD231SSD, x86asm.VFMADD231SS, x86asm.VFMADD231SD},
+		{VFMSUB132SSD, x86asm.VFMSUB132SS, x86asm.VFMSUB132SD},
+		{VFMSUB213SSD, x86asm.VFMSUB213SS, x86asm.VFMSUB213SD},
+		{VFMSUB231SSD, x86asm.VFMSUB231SS, x86asm.VFMSUB231SD},
+		{VFNMADD132SSD, x86asm.VFNMADD132SS, x86asm.VFNMADD132SD},
+		{VFNMADD213SSD, x86asm.VFNMADD213SS, x86asm.VFNMADD213SD},
+		{VFNMADD231SSD, x86asm.VFNMADD231SS, x86asm.VFNMADD231SD},
+		{VFNMSUB132SSD, x86asm.VFNMSUB132SS, x86asm.VFNMSUB132SD},
+		{VFNMSUB213SSD, x86asm.VFNMSUB213SS, x86asm.VFNMSUB213SD},
+		{VFNMSUB231SSD, x86asm.VFNMSUB231SS, x86asm.VFNMSUB231SD},
+	}
+
+	packed := []struct {
+		op     FMApacked
+		ps, pd x86asm.Op
+	}{
+		{VFMADD132PSD, x86asm.VFMADD132PS, x86asm.VFMADD132PD},
+		{VFMADD213PSD, x86asm.VFMADD213PS, x86asm.VFMADD213PD},
+		{VFMADD231PSD, x86asm.VFMADD231PS, x86asm.VFMADD231PD},
+		{VFMSUB132PSD, x86asm.VFMSUB132PS, x86asm.VFMSUB132PD},
+		{VFMSUB213PSD, x86asm.VFMSUB213PS, x86asm.VFMSUB213PD},
+		{VFMSUB231PSD, x86asm.VFMSUB231PS, x86asm.VFMSUB231PD},
+		{VFNMADD132PSD, x86asm.VFNMADD132PS, x86asm.VFNMADD132PD},
+		{VFNMADD213PSD, x86asm.VFNMADD213PS, x86asm.VFNMADD213PD},
+		{VFNMADD231PSD, x86asm.VFNMADD231PS, x86asm.VFNMADD231PD},
+		{VFNMSUB132PSD, x86asm.VFNMSUB132PS, x86asm.VFNMSUB132PD},
+		{VFNMSUB213PSD, x86asm.VFNMSUB213PS, x86asm.VFNMSUB213PD},
+		{VFNMSUB231PSD, x86asm.VFNMSUB231PS, x86asm.VFNMSUB231PD},
+	}
+
+	for _, x := range scalar {
+		if f := x.op.Feature(); f != FeatureFMA {
+			t.Errorf("%v: feature %v", x.ss, f)
+		}
+	}
+	for _, x := range packed {
+		if f := x.op.Feature(); f != FeatureFMA {
+			t.Errorf("%v: feature %v", x.ps, f)
+		}
+	}
+
+	for i := 0; i <= 15; i++ {
+		for j := 0; j <= 15; j++ {
+			k := (i + j + 1) & 15
+			xi, xj, xk := fmt.Sprintf("X%d", i), fmt.Sprintf("X%d", j), fmt.Sprintf("X%d", k)
+			yi, yj, yk := fmt.Sprintf("Y%d", i), fmt.Sprintf("Y%d", j), fmt.Sprintf("Y%d", k)
+			ri, rj, rk := Reg(i), Reg(j), Reg(k)
+
+			for _, x := range scalar {
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegReg(text, F32, ri, rk, rj) }), x.ss, xi, xk, xj)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegReg(text, F64, ri, rk, rj) }), x.sd, xi, xk, xj)
+			}
+
+			for _, x := range packed {
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegReg(text, Width128, F32, ri, rk, rj) }), x.ps, xi, xk, xj)
+				checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegReg(text, Width256, F64, ri, rk, rj) }), x.pd, yi, yk, yj)
+			}
+		}
+	}
+
+	for i := 0; i <= 15; i++ {
+		for _, base := range []int{0, 1, 2, 3, 6, 7, 8, 9, 10, 11, 14, 15} {
+			k := (i + base + 1) & 15
+			xi, xk := fmt.Sprintf("X%d", i), fmt.Sprintf("X%d", k)
+			yi, yk := fmt.Sprintf("Y%d", i), fmt.Sprintf("Y%d", k)
+			ri, rk, rb := Reg(i), Reg(k), Reg(base)
+
+			for disp, dispStr := range map[int32]string{0: "", 0x10: "+0x10", -0x1000: "-0x1000"} {
+				m := "[" + testGPRegs64[base] + dispStr + "]"
+
+				for _, x := range scalar {
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegMemDisp(text, F32, ri, rk, rb, disp) }), x.ss, xi, xk, m)
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegMemDisp(text, F64, ri, rk, rb, disp) }), x.sd, xi, xk, m)
+				}
+
+				for _, x := range packed {
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegMemDisp(text, Width256, F32, ri, rk, rb, disp) }), x.ps, yi, yk, m)
+					checkTestInst(t, encodeTestInst(t, func(text *Buf) { x.op.RegRegMemDisp(text, Width128, F64, ri, rk, rb, disp) }), x.pd, xi, xk, m)
+				}
+			}
+		}
+	}
+}
+
+func TestVEXErrors(t *testing.T) {
+	for _, fn := range []func(*Buf){
+		func(text *Buf) { MOVAPSD.RegRegReg(text, Width128, F32, 0, 1, 2) },
+		func(text *Buf) { CVTTSSD2SI.RegRegReg(text, F32, 0, 1, 2) },
+		func(text *Buf) { MOVSSD.RegRegMemDisp(text, F32, 0, 1, 2, 0) },
+		func(text *Buf) { PSHUFDi.RegRegRegImm8(text, Width128, 0, 1, 2, 0) },
+		func(text *Buf) { PSRA.RegRegReg(text, Width128, Quad, 0, 1, 2) },
+		func(text *Buf) { PMINS.RegRegReg(text, Width128, Quad, 0, 1, 2) },
+		func(text *Buf) { PBLENDi.RegRegRegImm8(text, Width128, Byte, 0, 1, 2, 0) },
+		func(text *Buf) { MOVOA.RegRegReg(text, Width256, 0, 1, 2) },
+		func(text *Buf) { PADD.RegRegReg(text, Width(64), Byte, 0, 1, 2) },
+		func(text *Buf) { MOVOU.WidthRegReg(text, Width(8), 0, 1) },
+		func(text *Buf) { PTEST.RegRegReg(text, Width128, 0, 1, 2) },
+		func(text *Buf) { SQRTPSD.RegRegReg(text, Width128, F32, 0, 1, 2) },
+		func(text *Buf) { CVTDQ2PS.RegRegReg(text, Width128, 0, 1, 2) },
+		func(text *Buf) { PABS.RegRegReg(text, Width128, Byte, 0, 1, 2) },
+		func(text *Buf) { PEXTR.RegRegRegImm8(text, Byte, 0, 1, 2, 0) },
+		func(text *Buf) { PINSR.RegRegRegImm8(text, Octet, 0, 1, 2, 0) },
+		func(text *Buf) { CMPPSD.RegRegRegImm8(text, Width512, F32, 0, 1, 2, CmpPredicateEQ) },
+		func(text *Buf) { VFMADD231PSD.RegRegReg(text, Width512, F32, 0, 1, 2) },
+	} {
+		text := &Buf{Buffer: buffer.NewLimited(nil, 32)}
+		fn(text)
+		if len(text.Errors) != 1 || len(text.Bytes()) != 0 {
+			t.Errorf("errors=%v bytes=%x", text.Errors, text.Bytes())
+		}
+	}
+}